@@ -0,0 +1,53 @@
+package billing
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/thanhpk/randstr"
+)
+
+// DevProvider stands in for a real processor during development. Checkouts
+// always succeed and webhooks are plain Event JSON authenticated with a
+// shared secret in the X-Billing-Secret header.
+type DevProvider struct {
+	webhookSecret string
+}
+
+func NewDevProvider(webhookSecret string) *DevProvider {
+	return &DevProvider{webhookSecret: webhookSecret}
+}
+
+func (d *DevProvider) Name() string {
+	return "dev"
+}
+
+func (d *DevProvider) CreateCheckout(ctx context.Context, req CheckoutRequest) (*Checkout, error) {
+	id := "dev_cs_" + randstr.Hex(12)
+
+	return &Checkout{
+		ID:  id,
+		URL: fmt.Sprintf("%s?session_id=%s&tier=%s", req.SuccessURL, id, req.Tier),
+	}, nil
+}
+
+func (d *DevProvider) CancelSubscription(ctx context.Context, subscriptionID string) error {
+	return nil
+}
+
+func (d *DevProvider) ParseWebhook(header http.Header, body []byte) (*Event, error) {
+	secret := header.Get("X-Billing-Secret")
+	if d.webhookSecret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(d.webhookSecret)) != 1 {
+		return nil, ErrInvalidSignature
+	}
+
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
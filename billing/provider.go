@@ -0,0 +1,84 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"habitat-server/models"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrIgnoredEvent     = errors.New("webhook event not handled")
+	ErrTierNotForSale   = errors.New("membership tier cannot be purchased")
+)
+
+// Provider is a payment processor that sells membership subscriptions.
+type Provider interface {
+	Name() string
+	CreateCheckout(ctx context.Context, req CheckoutRequest) (*Checkout, error)
+	// CancelSubscription stops renewal; the subscription stays active until
+	// the end of the paid period and the provider then sends a webhook.
+	CancelSubscription(ctx context.Context, subscriptionID string) error
+	ParseWebhook(header http.Header, body []byte) (*Event, error)
+}
+
+// Default is the provider used by the routes. It is set by Initialize.
+var Default Provider
+
+type CheckoutRequest struct {
+	UserID     uint
+	Email      string
+	Tier       models.MembershipTier
+	SuccessURL string
+	CancelURL  string
+}
+
+type Checkout struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+type EventType string
+
+const (
+	EventSubscriptionUpdated  EventType = "subscription.updated"
+	EventSubscriptionCanceled EventType = "subscription.canceled"
+	EventPaymentFailed        EventType = "payment.failed"
+)
+
+// Event is a provider webhook translated into what the server acts on.
+// UserID and Tier are zero when the provider does not echo them back, in
+// which case the subscription is looked up by SubscriptionID.
+type Event struct {
+	ID                string                    `json:"id"`
+	Type              EventType                 `json:"type"`
+	UserID            uint                      `json:"userID"`
+	Tier              models.MembershipTier     `json:"tier"`
+	SubscriptionID    string                    `json:"subscriptionID"`
+	CustomerID        string                    `json:"customerID"`
+	Status            models.SubscriptionStatus `json:"status"`
+	CurrentPeriodEnd  *time.Time                `json:"currentPeriodEnd"`
+	CancelAtPeriodEnd bool                      `json:"cancelAtPeriodEnd"`
+}
+
+func Initialize() {
+	switch os.Getenv("BILLING_PROVIDER") {
+	case "dev":
+		Default = NewDevProvider(os.Getenv("BILLING_WEBHOOK_SECRET"))
+	default:
+		Default = NewStripe(
+			os.Getenv("STRIPE_SECRET_KEY"),
+			os.Getenv("STRIPE_WEBHOOK_SECRET"),
+			map[models.MembershipTier]string{
+				models.PremiumTier: os.Getenv("STRIPE_PRICE_PREMIUM"),
+				models.ProTier:     os.Getenv("STRIPE_PRICE_PRO"),
+			},
+		)
+	}
+
+	log.Println("billing provider:", Default.Name())
+}
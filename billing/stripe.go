@@ -0,0 +1,208 @@
+package billing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"habitat-server/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const stripeAPIURL = "https://api.stripe.com/v1"
+
+// stripeSignatureTolerance bounds how old a signed webhook may be, to limit
+// replays.
+const stripeSignatureTolerance = 5 * time.Minute
+
+type Stripe struct {
+	secretKey     string
+	webhookSecret string
+	prices        map[models.MembershipTier]string
+	client        *http.Client
+}
+
+func NewStripe(secretKey string, webhookSecret string, prices map[models.MembershipTier]string) *Stripe {
+	return &Stripe{
+		secretKey:     secretKey,
+		webhookSecret: webhookSecret,
+		prices:        prices,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *Stripe) Name() string {
+	return "stripe"
+}
+
+func (s *Stripe) CreateCheckout(ctx context.Context, req CheckoutRequest) (*Checkout, error) {
+	price := s.prices[req.Tier]
+	if price == "" {
+		return nil, ErrTierNotForSale
+	}
+
+	userID := strconv.FormatUint(uint64(req.UserID), 10)
+
+	form := url.Values{}
+	form.Set("mode", "subscription")
+	form.Set("line_items[0][price]", price)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("success_url", req.SuccessURL)
+	form.Set("cancel_url", req.CancelURL)
+	form.Set("client_reference_id", userID)
+	form.Set("customer_email", req.Email)
+	form.Set("subscription_data[metadata][user_id]", userID)
+	form.Set("subscription_data[metadata][tier]", string(req.Tier))
+
+	var checkout Checkout
+	if err := s.post(ctx, "/checkout/sessions", form, &checkout); err != nil {
+		return nil, err
+	}
+
+	return &checkout, nil
+}
+
+func (s *Stripe) CancelSubscription(ctx context.Context, subscriptionID string) error {
+	form := url.Values{}
+	form.Set("cancel_at_period_end", "true")
+
+	return s.post(ctx, "/subscriptions/"+url.PathEscape(subscriptionID), form, nil)
+}
+
+func (s *Stripe) post(ctx context.Context, path string, form url.Values, dest interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stripeAPIURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.secretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var stripeErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(res.Body).Decode(&stripeErr)
+		return fmt.Errorf("stripe: %d %s", res.StatusCode, stripeErr.Error.Message)
+	}
+
+	if dest == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(dest)
+}
+
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			ID                string            `json:"id"`
+			Customer          string            `json:"customer"`
+			Subscription      string            `json:"subscription"`
+			Status            string            `json:"status"`
+			CurrentPeriodEnd  int64             `json:"current_period_end"`
+			CancelAtPeriodEnd bool              `json:"cancel_at_period_end"`
+			Metadata          map[string]string `json:"metadata"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+var stripeStatuses = map[string]models.SubscriptionStatus{
+	"active":             models.SubscriptionActive,
+	"trialing":           models.SubscriptionActive,
+	"past_due":           models.SubscriptionPastDue,
+	"unpaid":             models.SubscriptionPastDue,
+	"incomplete":         models.SubscriptionPending,
+	"canceled":           models.SubscriptionCanceled,
+	"incomplete_expired": models.SubscriptionCanceled,
+}
+
+func (s *Stripe) ParseWebhook(header http.Header, body []byte) (*Event, error) {
+	if err := s.verifySignature(header.Get("Stripe-Signature"), body); err != nil {
+		return nil, err
+	}
+
+	var stripeEvt stripeEvent
+	if err := json.Unmarshal(body, &stripeEvt); err != nil {
+		return nil, err
+	}
+
+	object := stripeEvt.Data.Object
+	event := &Event{ID: stripeEvt.ID, CustomerID: object.Customer}
+
+	switch stripeEvt.Type {
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
+		event.Type = EventSubscriptionUpdated
+		if stripeEvt.Type == "customer.subscription.deleted" {
+			event.Type = EventSubscriptionCanceled
+		}
+
+		userID, _ := strconv.ParseUint(object.Metadata["user_id"], 10, 32)
+		event.UserID = uint(userID)
+		event.Tier = models.MembershipTier(object.Metadata["tier"])
+		event.SubscriptionID = object.ID
+		event.Status = stripeStatuses[object.Status]
+		event.CancelAtPeriodEnd = object.CancelAtPeriodEnd
+		if object.CurrentPeriodEnd > 0 {
+			periodEnd := time.Unix(object.CurrentPeriodEnd, 0)
+			event.CurrentPeriodEnd = &periodEnd
+		}
+	case "invoice.payment_failed":
+		event.Type = EventPaymentFailed
+		event.SubscriptionID = object.Subscription
+		event.Status = models.SubscriptionPastDue
+	default:
+		return nil, ErrIgnoredEvent
+	}
+
+	return event, nil
+}
+
+// verifySignature checks a Stripe-Signature header of the form
+// "t=timestamp,v1=signature".
+func (s *Stripe) verifySignature(header string, body []byte) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(seconds, 0)) > stripeSignatureTolerance {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.webhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
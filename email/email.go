@@ -0,0 +1,78 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+)
+
+var ErrNoRecipient = errors.New("email has no recipient")
+
+// Message is a rendered email ready to hand to a provider.
+type Message struct {
+	To      string
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// Provider delivers rendered messages.
+type Provider interface {
+	Name() string
+	Send(ctx context.Context, from Address, message Message) error
+}
+
+type Address struct {
+	Email string
+	Name  string
+}
+
+// Default is the provider used by Send. It is set by Initialize.
+var Default Provider
+
+// Initialize picks the provider from EMAIL_PROVIDER: "smtp", "file" for
+// development, or Mailjet by default.
+func Initialize() {
+	switch os.Getenv("EMAIL_PROVIDER") {
+	case "smtp":
+		Default = NewSMTP(
+			os.Getenv("SMTP_HOST"),
+			os.Getenv("SMTP_PORT"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+		)
+	case "file":
+		Default = NewFileProvider(os.Getenv("EMAIL_OUTBOX"))
+	default:
+		Default = NewMailjet(os.Getenv("EMAIL_API_KEY"), os.Getenv("EMAIL_SECRET_KEY"))
+	}
+
+	log.Println("email provider:", Default.Name())
+}
+
+// From is the sender for all transactional email, set with EMAIL_FROM and
+// EMAIL_FROM_NAME.
+func From() Address {
+	name := os.Getenv("EMAIL_FROM_NAME")
+	if name == "" {
+		name = "Habitat"
+	}
+
+	return Address{Email: os.Getenv("EMAIL_FROM"), Name: name}
+}
+
+// Send renders the named template in the recipient's locale and delivers it.
+func Send(ctx context.Context, to string, locale string, name TemplateName, data interface{}) error {
+	if to == "" {
+		return ErrNoRecipient
+	}
+
+	message, err := Render(name, locale, data)
+	if err != nil {
+		return err
+	}
+	message.To = to
+
+	return Default.Send(ctx, From(), *message)
+}
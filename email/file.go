@@ -0,0 +1,39 @@
+package email
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// FileProvider writes each message to an .eml file in a directory instead
+// of sending it, or logs it when no directory is configured. It is meant for
+// development.
+type FileProvider struct {
+	dir string
+}
+
+func NewFileProvider(dir string) *FileProvider {
+	return &FileProvider{dir: dir}
+}
+
+func (f *FileProvider) Name() string {
+	return "file"
+}
+
+func (f *FileProvider) Send(ctx context.Context, from Address, message Message) error {
+	if f.dir == "" {
+		log.Printf("email to %s: %s\n%s", message.To, message.Subject, message.Text)
+		return nil
+	}
+
+	if err := os.MkdirAll(f.dir, 0o755); err != nil {
+		return err
+	}
+
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + ".eml"
+	return os.WriteFile(filepath.Join(f.dir, name), buildMIME(from, message), 0o644)
+}
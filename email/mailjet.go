@@ -0,0 +1,42 @@
+package email
+
+import (
+	"context"
+
+	"github.com/mailjet/mailjet-apiv3-go"
+)
+
+type Mailjet struct {
+	client *mailjet.Client
+}
+
+func NewMailjet(publicKey string, privateKey string) *Mailjet {
+	return &Mailjet{client: mailjet.NewMailjetClient(publicKey, privateKey)}
+}
+
+func (m *Mailjet) Name() string {
+	return "mailjet"
+}
+
+func (m *Mailjet) Send(ctx context.Context, from Address, message Message) error {
+	messagesInfo := []mailjet.InfoMessagesV31{
+		{
+			From: &mailjet.RecipientV31{
+				Email: from.Email,
+				Name:  from.Name,
+			},
+			To: &mailjet.RecipientsV31{
+				mailjet.RecipientV31{
+					Email: message.To,
+				},
+			},
+			Subject:  message.Subject,
+			HTMLPart: message.HTML,
+			TextPart: message.Text,
+		},
+	}
+
+	messages := mailjet.MessagesV31{Info: messagesInfo}
+	_, err := m.client.SendMailV31(&messages)
+	return err
+}
@@ -0,0 +1,44 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"time"
+
+	"github.com/thanhpk/randstr"
+)
+
+// buildMIME encodes a message as multipart/alternative with plain text and
+// HTML parts, for providers that speak raw RFC 5322.
+func buildMIME(from Address, message Message) []byte {
+	boundary := "habitat-" + randstr.Hex(16)
+	sender := mail.Address{Name: from.Name, Address: from.Email}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", sender.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", message.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+
+	writePart(&buf, boundary, "text/plain", message.Text)
+	writePart(&buf, boundary, "text/html", message.HTML)
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+
+	return buf.Bytes()
+}
+
+func writePart(buf *bytes.Buffer, boundary string, contentType string, body string) {
+	fmt.Fprintf(buf, "--%s\r\n", boundary)
+	fmt.Fprintf(buf, "Content-Type: %s; charset=utf-8\r\n", contentType)
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	writer := quotedprintable.NewWriter(buf)
+	writer.Write([]byte(body))
+	writer.Close()
+	buf.WriteString("\r\n")
+}
@@ -0,0 +1,33 @@
+package email
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+)
+
+type SMTP struct {
+	addr string
+	auth smtp.Auth
+}
+
+func NewSMTP(host string, port string, username string, password string) *SMTP {
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTP{addr: net.JoinHostPort(host, port), auth: auth}
+}
+
+func (s *SMTP) Name() string {
+	return "smtp"
+}
+
+func (s *SMTP) Send(ctx context.Context, from Address, message Message) error {
+	return smtp.SendMail(s.addr, s.auth, from.Email, []string{message.To}, buildMIME(from, message))
+}
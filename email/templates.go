@@ -0,0 +1,123 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+type TemplateName string
+
+const (
+	PasswordResetTemplate     TemplateName = "password_reset"
+	EmailVerificationTemplate TemplateName = "verify_email"
+	PasswordlessLoginTemplate TemplateName = "passwordless_login"
+)
+
+// DefaultLocale is used when a user has no locale or one we have no
+// templates for.
+const DefaultLocale = "en"
+
+// Locales lists the languages templates are written in.
+var Locales = []string{"en", "es"}
+
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+// htmlFuncs lets templates mark links the server built itself as safe.
+// html/template would otherwise replace app deep links such as exp:// with
+// a placeholder.
+var htmlFuncs = htmltemplate.FuncMap{
+	"trustedURL": func(link string) htmltemplate.URL {
+		return htmltemplate.URL(link)
+	},
+}
+
+type PasswordResetData struct {
+	Link string
+}
+
+type EmailVerificationData struct {
+	FirstName string
+	Link      string
+}
+
+type PasswordlessLoginData struct {
+	Code string
+	Link string
+}
+
+// Render builds a message from templates/<locale>/<name>.html and
+// <name>.txt. The subject is the "subject" block of the text template.
+func Render(name TemplateName, locale string, data interface{}) (*Message, error) {
+	locale = SupportedLocale(locale)
+
+	textPath := fmt.Sprintf("templates/%s/%s.txt", locale, name)
+	htmlPath := fmt.Sprintf("templates/%s/%s.html", locale, name)
+
+	// Data passed as a map renders "<no value>" for a missing key unless
+	// told to fail.
+	textTemplate, err := texttemplate.New(path.Base(textPath)).Option("missingkey=error").ParseFS(templateFS, textPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+	}
+
+	htmlTemplate, err := htmltemplate.New("layout").Option("missingkey=error").Funcs(htmlFuncs).ParseFS(templateFS, "templates/layout.html", htmlPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := textTemplate.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := textTemplate.ExecuteTemplate(&text, "body", data); err != nil {
+		return nil, err
+	}
+	if err := htmlTemplate.ExecuteTemplate(&html, "layout", data); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}, nil
+}
+
+// Preview renders a template without sending it, for checking copy and
+// layout in tests and tooling.
+func Preview(name TemplateName, locale string, data interface{}) (*Message, error) {
+	message, err := Render(name, locale, data)
+	if err != nil {
+		return nil, err
+	}
+	message.To = "preview@example.com"
+
+	return message, nil
+}
+
+// SupportedLocale maps a locale such as "es-MX" or an Accept-Language
+// header to one we have templates for.
+func SupportedLocale(locale string) string {
+	for _, candidate := range strings.Split(locale, ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(candidate), ";")
+		language, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		language, _, _ = strings.Cut(language, "_")
+
+		for _, supported := range Locales {
+			if language == supported {
+				return supported
+			}
+		}
+	}
+
+	return DefaultLocale
+}
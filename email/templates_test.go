@@ -0,0 +1,117 @@
+package email
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+)
+
+// templateData has sample data for every template. Each value is the data
+// type the template is sent with, so a template using a field that type
+// doesn't have fails to render.
+var templateData = map[TemplateName]interface{}{
+	PasswordResetTemplate: PasswordResetData{
+		Link: "https://habitat.example/reset?token=reset-token",
+	},
+	EmailVerificationTemplate: EmailVerificationData{
+		FirstName: "Ana",
+		Link:      "https://habitat.example/verify?token=verify-token",
+	},
+	PasswordlessLoginTemplate: PasswordlessLoginData{
+		Code: "482913",
+		Link: "exp://habitat.example/--/login?code=482913",
+	},
+}
+
+func TestRenderEveryTemplateAndLocale(t *testing.T) {
+	for _, locale := range Locales {
+		for name, data := range templateData {
+			t.Run(locale+"/"+string(name), func(t *testing.T) {
+				message, err := Render(name, locale, data)
+				if err != nil {
+					t.Fatalf("Render: %v", err)
+				}
+
+				if message.Subject == "" {
+					t.Error("subject is empty")
+				}
+				if strings.TrimSpace(message.Text) == "" {
+					t.Error("text body is empty")
+				}
+				if !strings.Contains(message.HTML, "<html") {
+					t.Error("HTML body is not wrapped in the layout")
+				}
+
+				for part, body := range map[string]string{"subject": message.Subject, "text": message.Text, "HTML": message.HTML} {
+					if strings.Contains(body, "<no value>") {
+						t.Errorf("%s has a missing value:\n%s", part, body)
+					}
+				}
+
+				if !strings.Contains(message.Text, linkOf(data)) {
+					t.Errorf("text body is missing the link %q", linkOf(data))
+				}
+				if !strings.Contains(message.HTML, `href="`+linkOf(data)+`"`) {
+					t.Errorf("HTML body is missing the link %q", linkOf(data))
+				}
+			})
+		}
+	}
+}
+
+// TestEveryTemplateIsCovered keeps templateData in step with the files, so a
+// new template or a missing translation fails here rather than in a send.
+func TestEveryTemplateIsCovered(t *testing.T) {
+	files := map[string]bool{}
+	err := fs.WalkDir(templateFS, "templates", func(file string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() && file != "templates/layout.html" {
+			files[file] = true
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, locale := range Locales {
+		for name := range templateData {
+			for _, extension := range []string{".html", ".txt"} {
+				file := path.Join("templates", locale, string(name)+extension)
+				if !files[file] {
+					t.Errorf("%s is missing", file)
+				}
+				delete(files, file)
+			}
+		}
+	}
+
+	for file := range files {
+		t.Errorf("%s has no sample data in templateData or is in an unsupported locale", file)
+	}
+}
+
+func TestRenderFailsOnMissingKeys(t *testing.T) {
+	_, err := Render(PasswordlessLoginTemplate, DefaultLocale, map[string]string{"Link": "https://habitat.example"})
+	if err == nil {
+		t.Fatal("rendering without a code succeeded")
+	}
+
+	_, err = Render(EmailVerificationTemplate, DefaultLocale, PasswordResetData{Link: "https://habitat.example"})
+	if err == nil {
+		t.Fatal("rendering with the wrong data type succeeded")
+	}
+}
+
+func linkOf(data interface{}) string {
+	switch data := data.(type) {
+	case PasswordResetData:
+		return data.Link
+	case EmailVerificationData:
+		return data.Link
+	case PasswordlessLoginData:
+		return data.Link
+	}
+
+	return ""
+}
@@ -0,0 +1,134 @@
+package location
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ErrAddressNotFound = errors.New("address could not be geocoded")
+
+// minConfidence is the lowest match confidence accepted for a listing
+// address; anything below street level is treated as invalid.
+const minConfidence = 0.6
+
+const defaultMismatchMeters = 250
+
+const earthRadiusMeters = 6371000
+
+type AddressInput struct {
+	Street     string
+	City       string
+	State      string
+	PostalCode string
+	Country    string
+}
+
+func (a AddressInput) String() string {
+	var parts []string
+	for _, part := range []string{a.Street, a.City, a.State, a.PostalCode, a.Country} {
+		if strings.TrimSpace(part) != "" {
+			parts = append(parts, strings.TrimSpace(part))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+type GeocodedAddress struct {
+	Place      Place
+	Normalized string
+	Confidence float64
+}
+
+// GeocodeAddress resolves a postal address to its best match and rejects
+// results that are too vague to place a listing on the map.
+func GeocodeAddress(ctx context.Context, geocoder Geocoder, address AddressInput) (*GeocodedAddress, error) {
+	places, err := geocoder.Search(ctx, address.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var best *GeocodedAddress
+	for _, place := range places {
+		if address.Country != "" && place.Address.CountryCode != "" &&
+			!strings.EqualFold(place.Address.CountryCode, address.Country) {
+			continue
+		}
+
+		confidence := Confidence(place)
+		if best == nil || confidence > best.Confidence {
+			best = &GeocodedAddress{
+				Place:      place,
+				Normalized: FormatAddress(place.Address),
+				Confidence: confidence,
+			}
+		}
+	}
+
+	if best == nil || best.Confidence < minConfidence {
+		return nil, ErrAddressNotFound
+	}
+
+	return best, nil
+}
+
+// Confidence scores how precisely a place pins down an address, from 0 to 1.
+func Confidence(place Place) float64 {
+	switch place.MatchLevel {
+	case "building", "venue":
+		return 1
+	case "street":
+		return 0.7
+	case "neighbourhood", "suburb", "island":
+		return 0.4
+	case "postalcode", "city", "county", "state", "country":
+		return 0.2
+	}
+
+	if place.Address.HouseNumber != "" && place.Address.Street != "" {
+		return 0.8
+	}
+
+	return math.Min(place.Importance, 0.5)
+}
+
+// FormatAddress renders an address as a single normalized line.
+func FormatAddress(address Address) string {
+	street := strings.TrimSpace(address.HouseNumber + " " + address.Street)
+
+	var parts []string
+	for _, part := range []string{street, address.City, address.State, address.PostalCode, address.CountryCode} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// DistanceMeters returns the great-circle distance between two points.
+func DistanceMeters(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
+	toRadians := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRadians(lat2 - lat1)
+	dLng := toRadians(lng2 - lng1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(a))
+}
+
+// MismatchThresholdMeters is how far client supplied coordinates may be from
+// the geocoded address before the listing is flagged.
+func MismatchThresholdMeters() float64 {
+	if threshold, err := strconv.ParseFloat(os.Getenv("GEOCODE_MISMATCH_METERS"), 64); err == nil && threshold > 0 {
+		return threshold
+	}
+
+	return defaultMismatchMeters
+}
@@ -0,0 +1,58 @@
+package location
+
+import (
+	"context"
+	"encoding/json"
+	"habitat-server/storage"
+	"strconv"
+	"time"
+)
+
+const cacheTTL = 7 * 24 * time.Hour
+
+// CachedGeocoder stores responses of the wrapped geocoder in Redis keyed by
+// the normalized query. Cache failures fall through to the provider.
+type CachedGeocoder struct {
+	geocoder Geocoder
+	ttl      time.Duration
+}
+
+func NewCachedGeocoder(geocoder Geocoder, ttl time.Duration) *CachedGeocoder {
+	return &CachedGeocoder{geocoder: geocoder, ttl: ttl}
+}
+
+func (c *CachedGeocoder) Autocomplete(ctx context.Context, query string, limit int) ([]Place, error) {
+	key := "geocode:autocomplete:" + strconv.Itoa(limit) + ":" + NormalizeQuery(query)
+
+	return c.cached(ctx, key, func() ([]Place, error) {
+		return c.geocoder.Autocomplete(ctx, query, limit)
+	})
+}
+
+func (c *CachedGeocoder) Search(ctx context.Context, query string) ([]Place, error) {
+	key := "geocode:search:" + NormalizeQuery(query)
+
+	return c.cached(ctx, key, func() ([]Place, error) {
+		return c.geocoder.Search(ctx, query)
+	})
+}
+
+func (c *CachedGeocoder) cached(ctx context.Context, key string, fetch func() ([]Place, error)) ([]Place, error) {
+	if body, err := storage.Redis.Get(ctx, key).Bytes(); err == nil {
+		var places []Place
+		if json.Unmarshal(body, &places) == nil {
+			return places, nil
+		}
+	}
+
+	places, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	if body, err := json.Marshal(places); err == nil {
+		storage.Redis.Set(ctx, key, body, c.ttl)
+	}
+
+	return places, nil
+}
@@ -0,0 +1,166 @@
+package location
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	ModeDriving = "driving"
+	ModeWalking = "walking"
+	ModeCycling = "cycling"
+	ModeTransit = "transit"
+)
+
+var ErrUnsupportedMode = errors.New("travel mode not supported by router")
+
+// averageSpeeds are door to door speeds in meters per second used for the
+// straight-line estimate.
+var averageSpeeds = map[string]float64{
+	ModeDriving: 11,
+	ModeWalking: 1.4,
+	ModeCycling: 4.5,
+	ModeTransit: 7,
+}
+
+// detourFactor accounts for streets not running in straight lines.
+const detourFactor = 1.3
+
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+type Route struct {
+	DistanceMeters  float64 `json:"distanceMeters"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// Router computes travel along the street network.
+type Router interface {
+	Route(ctx context.Context, from Point, to Point, mode string) (*Route, error)
+}
+
+// Routing is the router used by the routes. It is nil when no routing
+// service is configured, in which case only straight-line estimates are
+// returned.
+var Routing Router
+
+func initializeRouting() {
+	if os.Getenv("ROUTER") == "none" {
+		return
+	}
+
+	Routing = NewOSRMRouter(os.Getenv("OSRM_URL"))
+}
+
+type Commute struct {
+	Mode         string `json:"mode"`
+	StraightLine Route  `json:"straightLine"`
+	Routed       *Route `json:"routed"`
+}
+
+func IsMode(mode string) bool {
+	_, ok := averageSpeeds[mode]
+	return ok
+}
+
+// EstimateCommute returns the straight-line estimate between two points and,
+// when a router is available for the mode, the routed travel time.
+func EstimateCommute(ctx context.Context, router Router, from Point, to Point, mode string) (*Commute, error) {
+	distance := DistanceMeters(from.Lat, from.Lng, to.Lat, to.Lng)
+
+	commute := &Commute{
+		Mode: mode,
+		StraightLine: Route{
+			DistanceMeters:  math.Round(distance),
+			DurationSeconds: math.Round(distance * detourFactor / averageSpeeds[mode]),
+		},
+	}
+
+	if router == nil {
+		return commute, nil
+	}
+
+	routed, err := router.Route(ctx, from, to, mode)
+	if errors.Is(err, ErrUnsupportedMode) {
+		return commute, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	commute.Routed = routed
+	return commute, nil
+}
+
+const osrmURL = "https://router.project-osrm.org"
+
+var osrmProfiles = map[string]string{
+	ModeDriving: "driving",
+	ModeWalking: "foot",
+	ModeCycling: "bike",
+}
+
+type OSRMRouter struct {
+	url    string
+	client *http.Client
+}
+
+func NewOSRMRouter(endpoint string) *OSRMRouter {
+	if endpoint == "" {
+		endpoint = osrmURL
+	}
+
+	return &OSRMRouter{
+		url:    endpoint,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (r *OSRMRouter) Route(ctx context.Context, from Point, to Point, mode string) (*Route, error) {
+	profile, ok := osrmProfiles[mode]
+	if !ok {
+		return nil, ErrUnsupportedMode
+	}
+
+	endpoint := fmt.Sprintf("%s/route/v1/%s/%f,%f;%f,%f?overview=false",
+		r.url, profile, from.Lng, from.Lat, to.Lng, to.Lat)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		Code   string `json:"code"`
+		Routes []struct {
+			Distance float64 `json:"distance"`
+			Duration float64 `json:"duration"`
+		} `json:"routes"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	if body.Code != "Ok" || len(body.Routes) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrProviderUnavailable, body.Code)
+	}
+
+	return &Route{
+		DistanceMeters:  math.Round(body.Routes[0].Distance),
+		DurationSeconds: math.Round(body.Routes[0].Duration),
+	}, nil
+}
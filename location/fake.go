@@ -0,0 +1,78 @@
+package location
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+)
+
+// FakeGeocoder answers queries from a fixed set of fixtures keyed by
+// normalized query. It is used for local development and tests so no
+// LocationIQ quota is spent.
+type FakeGeocoder struct {
+	fixtures map[string][]Place
+}
+
+func NewFakeGeocoder(fixtures map[string][]Place) *FakeGeocoder {
+	normalized := make(map[string][]Place, len(fixtures))
+	for query, places := range fixtures {
+		normalized[NormalizeQuery(query)] = places
+	}
+
+	return &FakeGeocoder{fixtures: normalized}
+}
+
+// LoadFakeGeocoder reads fixtures from a JSON file shaped as
+// {"query": [place, ...]}.
+func LoadFakeGeocoder(path string) (*FakeGeocoder, error) {
+	fixtures := make(map[string][]Place)
+	if path == "" {
+		return NewFakeGeocoder(fixtures), nil
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(body, &fixtures); err != nil {
+		return nil, err
+	}
+
+	return NewFakeGeocoder(fixtures), nil
+}
+
+func (f *FakeGeocoder) Autocomplete(ctx context.Context, query string, limit int) ([]Place, error) {
+	prefix := NormalizeQuery(query)
+
+	var keys []string
+	for key := range f.fixtures {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	places := []Place{}
+	for _, key := range keys {
+		for _, place := range f.fixtures[key] {
+			if len(places) >= limit {
+				return places, nil
+			}
+			places = append(places, place)
+		}
+	}
+
+	return places, nil
+}
+
+func (f *FakeGeocoder) Search(ctx context.Context, query string) ([]Place, error) {
+	places, ok := f.fixtures[NormalizeQuery(query)]
+	if !ok {
+		return []Place{}, nil
+	}
+
+	return places, nil
+}
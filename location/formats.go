@@ -0,0 +1,81 @@
+package location
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrUnsupportedCountry = errors.New("country not supported")
+	ErrStateRequired      = errors.New("state is required for this country")
+	ErrInvalidPostalCode  = errors.New("postal code is invalid for this country")
+)
+
+type AddressFormat struct {
+	StateRequired bool
+	PostalCode    *regexp.Regexp
+	Currency      string
+}
+
+// addressFormats is keyed by ISO 3166-1 alpha-2 country code.
+var addressFormats = map[string]AddressFormat{
+	"US": {StateRequired: true, PostalCode: regexp.MustCompile(`^\d{5}(-\d{4})?$`), Currency: "USD"},
+	"CA": {StateRequired: true, PostalCode: regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`), Currency: "CAD"},
+	"MX": {StateRequired: true, PostalCode: regexp.MustCompile(`^\d{5}$`), Currency: "MXN"},
+	"GB": {PostalCode: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`), Currency: "GBP"},
+	"IE": {PostalCode: regexp.MustCompile(`^([A-Z]\d{2}|D6W) ?[A-Z\d]{4}$`), Currency: "EUR"},
+	"FR": {PostalCode: regexp.MustCompile(`^\d{5}$`), Currency: "EUR"},
+	"DE": {PostalCode: regexp.MustCompile(`^\d{5}$`), Currency: "EUR"},
+	"ES": {PostalCode: regexp.MustCompile(`^\d{5}$`), Currency: "EUR"},
+	"IT": {PostalCode: regexp.MustCompile(`^\d{5}$`), Currency: "EUR"},
+	"NL": {PostalCode: regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`), Currency: "EUR"},
+	"CH": {PostalCode: regexp.MustCompile(`^\d{4}$`), Currency: "CHF"},
+	"SE": {PostalCode: regexp.MustCompile(`^\d{3} ?\d{2}$`), Currency: "SEK"},
+	"AU": {StateRequired: true, PostalCode: regexp.MustCompile(`^\d{4}$`), Currency: "AUD"},
+	"NZ": {PostalCode: regexp.MustCompile(`^\d{4}$`), Currency: "NZD"},
+	"IN": {StateRequired: true, PostalCode: regexp.MustCompile(`^\d{6}$`), Currency: "INR"},
+	"BR": {StateRequired: true, PostalCode: regexp.MustCompile(`^\d{5}-?\d{3}$`), Currency: "BRL"},
+	"JP": {StateRequired: true, PostalCode: regexp.MustCompile(`^\d{3}-?\d{4}$`), Currency: "JPY"},
+	"KR": {PostalCode: regexp.MustCompile(`^\d{5}$`), Currency: "KRW"},
+}
+
+func LookupAddressFormat(country string) (AddressFormat, bool) {
+	format, ok := addressFormats[strings.ToUpper(country)]
+	return format, ok
+}
+
+// NormalizePostalCode uppercases and trims a postal code so it can be
+// matched against the country's format.
+func NormalizePostalCode(postalCode string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(postalCode), " "))
+}
+
+// ValidatePostalAddress checks the parts of an address whose rules differ by
+// country.
+func ValidatePostalAddress(country string, state string, postalCode string) error {
+	format, ok := LookupAddressFormat(country)
+	if !ok {
+		return ErrUnsupportedCountry
+	}
+
+	if format.StateRequired && strings.TrimSpace(state) == "" {
+		return ErrStateRequired
+	}
+
+	if !format.PostalCode.MatchString(NormalizePostalCode(postalCode)) {
+		return ErrInvalidPostalCode
+	}
+
+	return nil
+}
+
+// DefaultCurrency returns the currency listings in a country are priced in.
+func DefaultCurrency(country string) string {
+	format, ok := LookupAddressFormat(country)
+	if !ok {
+		return "USD"
+	}
+
+	return format.Currency
+}
@@ -0,0 +1,64 @@
+package location
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+)
+
+// Geocoder resolves free-text queries into places.
+type Geocoder interface {
+	Autocomplete(ctx context.Context, query string, limit int) ([]Place, error)
+	Search(ctx context.Context, query string) ([]Place, error)
+}
+
+var ErrProviderUnavailable = errors.New("geocoding provider unavailable")
+
+// Default is the geocoder used by the routes. It is set by Initialize.
+var Default Geocoder
+
+type Place struct {
+	PlaceID     string  `json:"placeID"`
+	DisplayName string  `json:"displayName"`
+	Lat         float64 `json:"lat"`
+	Lng         float64 `json:"lng"`
+	Type        string  `json:"type"`
+	Class       string  `json:"class"`
+	Importance  float64 `json:"importance"`
+	MatchLevel  string  `json:"matchLevel"`
+	Address     Address `json:"address"`
+}
+
+type Address struct {
+	Name        string `json:"name"`
+	HouseNumber string `json:"houseNumber"`
+	Street      string `json:"street"`
+	City        string `json:"city"`
+	State       string `json:"state"`
+	PostalCode  string `json:"postalCode"`
+	Country     string `json:"country"`
+	CountryCode string `json:"countryCode"`
+}
+
+func Initialize() {
+	var geocoder Geocoder
+
+	switch os.Getenv("GEOCODER_PROVIDER") {
+	case "fake":
+		fake, err := LoadFakeGeocoder(os.Getenv("GEOCODER_FIXTURES"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		geocoder = fake
+	default:
+		geocoder = NewLocationIQ(os.Getenv("LOCATION_TOKEN"))
+	}
+
+	Default = NewCachedGeocoder(geocoder, cacheTTL)
+
+	if err := initializePOIs(); err != nil {
+		log.Fatal(err)
+	}
+	initializeRouting()
+}
@@ -0,0 +1,144 @@
+package location
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const locationIQBaseURL = "https://api.locationiq.com/v1"
+
+type LocationIQ struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+func NewLocationIQ(apiKey string) *LocationIQ {
+	return &LocationIQ{
+		apiKey:  apiKey,
+		baseURL: locationIQBaseURL,
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (l *LocationIQ) Autocomplete(ctx context.Context, query string, limit int) ([]Place, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("limit", strconv.Itoa(limit))
+
+	return l.fetch(ctx, "/autocomplete.php", params)
+}
+
+func (l *LocationIQ) Search(ctx context.Context, query string) ([]Place, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("format", "json")
+	params.Set("dedupe", "1")
+	params.Set("addressdetails", "1")
+	params.Set("matchquality", "1")
+	params.Set("normalizeaddress", "1")
+	params.Set("normalizecity", "1")
+
+	return l.fetch(ctx, "/search.php", params)
+}
+
+func (l *LocationIQ) fetch(ctx context.Context, path string, params url.Values) ([]Place, error) {
+	params.Set("key", l.apiKey)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.baseURL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := l.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	// LocationIQ answers 404 with an error body when nothing matches.
+	if res.StatusCode == http.StatusNotFound {
+		return []Place{}, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", ErrProviderUnavailable, res.StatusCode)
+	}
+
+	var results []locationIQResult
+	if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+		return nil, err
+	}
+
+	places := make([]Place, 0, len(results))
+	for _, result := range results {
+		places = append(places, result.toPlace())
+	}
+
+	return places, nil
+}
+
+type locationIQResult struct {
+	PlaceID      string  `json:"place_id"`
+	Lat          string  `json:"lat"`
+	Lon          string  `json:"lon"`
+	DisplayName  string  `json:"display_name"`
+	Class        string  `json:"class"`
+	Type         string  `json:"type"`
+	Importance   float64 `json:"importance"`
+	MatchQuality struct {
+		MatchLevel string `json:"matchlevel"`
+	} `json:"matchquality"`
+	Address struct {
+		Name        string `json:"name"`
+		HouseNumber string `json:"house_number"`
+		Road        string `json:"road"`
+		City        string `json:"city"`
+		Town        string `json:"town"`
+		Village     string `json:"village"`
+		State       string `json:"state"`
+		Postcode    string `json:"postcode"`
+		Country     string `json:"country"`
+		CountryCode string `json:"country_code"`
+	} `json:"address"`
+}
+
+func (r locationIQResult) toPlace() Place {
+	lat, _ := strconv.ParseFloat(r.Lat, 64)
+	lng, _ := strconv.ParseFloat(r.Lon, 64)
+
+	city := r.Address.City
+	if city == "" {
+		city = r.Address.Town
+	}
+	if city == "" {
+		city = r.Address.Village
+	}
+
+	return Place{
+		PlaceID:     r.PlaceID,
+		DisplayName: r.DisplayName,
+		Lat:         lat,
+		Lng:         lng,
+		Type:        r.Type,
+		Class:       r.Class,
+		Importance:  r.Importance,
+		MatchLevel:  r.MatchQuality.MatchLevel,
+		Address: Address{
+			Name:        r.Address.Name,
+			HouseNumber: r.Address.HouseNumber,
+			Street:      r.Address.Road,
+			City:        city,
+			State:       r.Address.State,
+			PostalCode:  r.Address.Postcode,
+			Country:     r.Address.Country,
+			CountryCode: strings.ToUpper(r.Address.CountryCode),
+		},
+	}
+}
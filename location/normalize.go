@@ -0,0 +1,47 @@
+package location
+
+import (
+	"strings"
+	"unicode"
+)
+
+var streetAbbreviations = map[string]string{
+	"street":    "st",
+	"avenue":    "ave",
+	"boulevard": "blvd",
+	"road":      "rd",
+	"drive":     "dr",
+	"lane":      "ln",
+	"court":     "ct",
+	"place":     "pl",
+	"terrace":   "ter",
+	"highway":   "hwy",
+	"parkway":   "pkwy",
+	"north":     "n",
+	"south":     "s",
+	"east":      "e",
+	"west":      "w",
+	"apartment": "apt",
+	"suite":     "ste",
+}
+
+// NormalizeQuery lowercases a query, strips punctuation, collapses
+// whitespace and abbreviates common street words so that equivalent
+// addresses share a cache entry.
+func NormalizeQuery(query string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '#' || r == '-' {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, query)
+
+	words := strings.Fields(cleaned)
+	for i, word := range words {
+		if abbreviation, ok := streetAbbreviations[word]; ok {
+			words[i] = abbreviation
+		}
+	}
+
+	return strings.Join(words, " ")
+}
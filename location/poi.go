@@ -0,0 +1,253 @@
+package location
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	CategoryTransit    = "transit"
+	CategoryGrocery    = "grocery"
+	CategorySchool     = "school"
+	CategoryPark       = "park"
+	CategoryPharmacy   = "pharmacy"
+	CategoryRestaurant = "restaurant"
+)
+
+var Categories = []string{
+	CategoryTransit,
+	CategoryGrocery,
+	CategorySchool,
+	CategoryPark,
+	CategoryPharmacy,
+	CategoryRestaurant,
+}
+
+const DefaultNeighborhoodRadius = 1000
+
+type POI struct {
+	Name     string  `json:"name"`
+	Category string  `json:"category"`
+	Lat      float64 `json:"lat"`
+	Lng      float64 `json:"lng"`
+}
+
+// POISource finds points of interest around a coordinate.
+type POISource interface {
+	Nearby(ctx context.Context, lat float64, lng float64, radiusMeters float64) ([]POI, error)
+}
+
+// POIs is the points of interest source used by the routes. It is set by
+// Initialize.
+var POIs POISource
+
+func initializePOIs() error {
+	switch os.Getenv("POI_SOURCE") {
+	case "local":
+		source, err := LoadLocalPOISource(os.Getenv("POI_EXTRACT"))
+		if err != nil {
+			return err
+		}
+		POIs = source
+	default:
+		POIs = NewOverpassPOISource(os.Getenv("OVERPASS_URL"))
+	}
+
+	return nil
+}
+
+// LocalPOISource serves points of interest from an OSM extract that has been
+// preprocessed into a JSON array of POI.
+type LocalPOISource struct {
+	pois []POI
+}
+
+func LoadLocalPOISource(path string) (*LocalPOISource, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pois []POI
+	if err := json.Unmarshal(body, &pois); err != nil {
+		return nil, err
+	}
+
+	return &LocalPOISource{pois: pois}, nil
+}
+
+func (s *LocalPOISource) Nearby(ctx context.Context, lat float64, lng float64, radiusMeters float64) ([]POI, error) {
+	// Cheap bounding box check before the exact distance.
+	latDelta := radiusMeters / 111320
+	lngDelta := radiusMeters / (111320 * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+
+	var nearby []POI
+	for _, poi := range s.pois {
+		if math.Abs(poi.Lat-lat) > latDelta || math.Abs(poi.Lng-lng) > lngDelta {
+			continue
+		}
+		if DistanceMeters(lat, lng, poi.Lat, poi.Lng) <= radiusMeters {
+			nearby = append(nearby, poi)
+		}
+	}
+
+	return nearby, nil
+}
+
+const overpassURL = "https://overpass-api.de/api/interpreter"
+
+// overpassFilters maps each category to the OSM tags that belong to it.
+var overpassFilters = map[string][]string{
+	CategoryTransit:    {`["public_transport"="station"]`, `["railway"="station"]`, `["highway"="bus_stop"]`},
+	CategoryGrocery:    {`["shop"="supermarket"]`, `["shop"="grocery"]`, `["shop"="convenience"]`},
+	CategorySchool:     {`["amenity"="school"]`},
+	CategoryPark:       {`["leisure"="park"]`},
+	CategoryPharmacy:   {`["amenity"="pharmacy"]`},
+	CategoryRestaurant: {`["amenity"="restaurant"]`, `["amenity"="cafe"]`},
+}
+
+// OverpassPOISource queries the OpenStreetMap Overpass API.
+type OverpassPOISource struct {
+	url    string
+	client *http.Client
+}
+
+func NewOverpassPOISource(endpoint string) *OverpassPOISource {
+	if endpoint == "" {
+		endpoint = overpassURL
+	}
+
+	return &OverpassPOISource{
+		url:    endpoint,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *OverpassPOISource) Nearby(ctx context.Context, lat float64, lng float64, radiusMeters float64) ([]POI, error) {
+	var query strings.Builder
+	query.WriteString("[out:json][timeout:8];(")
+	for _, category := range Categories {
+		for _, filter := range overpassFilters[category] {
+			fmt.Fprintf(&query, "node%s(around:%.0f,%f,%f);", filter, radiusMeters, lat, lng)
+		}
+	}
+	query.WriteString(");out body;")
+
+	form := url.Values{}
+	form.Set("data", query.String())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", ErrProviderUnavailable, res.StatusCode)
+	}
+
+	var body struct {
+		Elements []struct {
+			Lat  float64           `json:"lat"`
+			Lon  float64           `json:"lon"`
+			Tags map[string]string `json:"tags"`
+		} `json:"elements"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	pois := make([]POI, 0, len(body.Elements))
+	for _, element := range body.Elements {
+		category := categorize(element.Tags)
+		if category == "" {
+			continue
+		}
+
+		pois = append(pois, POI{
+			Name:     element.Tags["name"],
+			Category: category,
+			Lat:      element.Lat,
+			Lng:      element.Lon,
+		})
+	}
+
+	return pois, nil
+}
+
+func categorize(tags map[string]string) string {
+	for _, category := range Categories {
+		for _, filter := range overpassFilters[category] {
+			// filters look like ["key"="value"]
+			parts := strings.Split(strings.Trim(filter, `[]`), "=")
+			if tags[strings.Trim(parts[0], `"`)] == strings.Trim(parts[1], `"`) {
+				return category
+			}
+		}
+	}
+
+	return ""
+}
+
+type Neighborhood struct {
+	RadiusMeters float64           `json:"radiusMeters"`
+	Categories   []CategorySummary `json:"categories"`
+}
+
+type CategorySummary struct {
+	Category string      `json:"category"`
+	Count    int         `json:"count"`
+	Nearest  []NearbyPOI `json:"nearest"`
+}
+
+type NearbyPOI struct {
+	POI
+	DistanceMeters float64 `json:"distanceMeters"`
+}
+
+const nearestPerCategory = 3
+
+// SummarizeNeighborhood groups points of interest by category, keeping the
+// closest few of each.
+func SummarizeNeighborhood(pois []POI, lat float64, lng float64, radiusMeters float64) Neighborhood {
+	byCategory := make(map[string][]NearbyPOI)
+	for _, poi := range pois {
+		byCategory[poi.Category] = append(byCategory[poi.Category], NearbyPOI{
+			POI:            poi,
+			DistanceMeters: math.Round(DistanceMeters(lat, lng, poi.Lat, poi.Lng)),
+		})
+	}
+
+	neighborhood := Neighborhood{RadiusMeters: radiusMeters}
+	for _, category := range Categories {
+		nearby := byCategory[category]
+		sort.Slice(nearby, func(i int, j int) bool {
+			return nearby[i].DistanceMeters < nearby[j].DistanceMeters
+		})
+
+		summary := CategorySummary{Category: category, Count: len(nearby), Nearest: []NearbyPOI{}}
+		if len(nearby) > nearestPerCategory {
+			summary.Nearest = nearby[:nearestPerCategory]
+		} else if len(nearby) > 0 {
+			summary.Nearest = nearby
+		}
+
+		neighborhood.Categories = append(neighborhood.Categories, summary)
+	}
+
+	return neighborhood
+}
@@ -1,11 +1,22 @@
 package main
 
 import (
+	"habitat-server/billing"
+	"habitat-server/email"
+	"habitat-server/location"
+	"habitat-server/models"
+	"habitat-server/money"
+	"habitat-server/oidc"
+	"habitat-server/privacy"
+	"habitat-server/ratings"
 	"habitat-server/routes"
+	"habitat-server/scanner"
 	"habitat-server/storage"
 	"habitat-server/utils"
 
+	"log"
 	"os"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/kataras/iris/v12"
@@ -15,8 +26,28 @@ import (
 
 func main() {
 	storage.InitializeDB()
+
+	// `habitat-server recompute-ratings` repairs property rating aggregates
+	// that have drifted from their reviews, then exits.
+	if len(os.Args) > 1 && os.Args[1] == "recompute-ratings" {
+		repaired, err := ratings.RecomputeAll(storage.DB)
+		if err != nil {
+			log.Fatal("recomputing ratings: ", err)
+		}
+
+		log.Println("repaired ratings of", repaired, "properties")
+		return
+	}
+
 	storage.InitializeStorage(storage.DB)
 	storage.InitializeRedis()
+	location.Initialize()
+	money.Initialize()
+	billing.Initialize()
+	email.Initialize()
+	oidc.Initialize()
+	privacy.Initialize()
+	scanner.Initialize()
 
 
 
@@ -30,11 +61,36 @@ func main() {
 		return new(utils.ForgotPasswordToken)
 	})
 
+	verifyTokenVerifier := jwt.NewVerifier(jwt.HS256, []byte(os.Getenv("VERIFY_TOKEN_SECRET")))
+	verifyTokenVerifierMiddleware := verifyTokenVerifier.Verify(func() interface{} {
+		return new(utils.EmailVerificationToken)
+	})
+
+	twoFactorChallengeVerifier := jwt.NewVerifier(jwt.HS256, []byte(os.Getenv("TWO_FACTOR_CHALLENGE_SECRET")))
+	twoFactorChallengeVerifierMiddleware := twoFactorChallengeVerifier.Verify(func() interface{} {
+		return new(utils.TwoFactorChallengeToken)
+	})
+
+	twoFactorEnrollmentVerifier := jwt.NewVerifier(jwt.HS256, []byte(os.Getenv("TWO_FACTOR_ENROLLMENT_SECRET")))
+	twoFactorEnrollmentVerifierMiddleware := twoFactorEnrollmentVerifier.Verify(func() interface{} {
+		return new(utils.TwoFactorEnrollmentToken)
+	}, jwt.Expected{Audience: []string{utils.TwoFactorEnrollmentAudience}})
+
 	accessTokenVerifier := jwt.NewVerifier(jwt.HS256, []byte(os.Getenv("ACCESS_TOKEN_SECRET")))
 	accessTokenVerifier.WithDefaultBlocklist()
 	accessTokenVerifierMiddleware := accessTokenVerifier.Verify(func() interface{} {
 		return new(utils.AccessToken)
-	})
+	}, utils.SessionValidator{})
+
+	// Lets anonymous requests through while still exposing the claims of
+	// authenticated ones, e.g. to rate limit per user.
+	optionalAccessTokenVerifier := jwt.NewVerifier(jwt.HS256, []byte(os.Getenv("ACCESS_TOKEN_SECRET")))
+	optionalAccessTokenVerifier.ErrorHandler = func(ctx iris.Context, err error) {
+		ctx.Next()
+	}
+	optionalAccessTokenVerifierMiddleware := optionalAccessTokenVerifier.Verify(func() interface{} {
+		return new(utils.AccessToken)
+	}, utils.SessionValidator{})
 
 	refreshTokenVerifier := jwt.NewVerifier(jwt.HS256, []byte(os.Getenv("REFRESH_TOKEN_SECRET")))
 	refreshTokenVerifier.WithDefaultBlocklist()
@@ -52,34 +108,80 @@ func main() {
 		return tokenInput.RefreshToken
 	})
 
-	location := app.Party("/api/location")
+	locationRateLimit := utils.RateLimit("location", 60, time.Minute, utils.UserOrIPKey)
+	verificationResendRateLimit := utils.RateLimit("verification-resend", 3, time.Hour, utils.UserOrIPKey)
+	loginIPRateLimit := utils.RateLimit("login-ip", 30, 15*time.Minute, utils.IPKey)
+	loginAccountRateLimit := utils.RateLimit("login-account", 10, 15*time.Minute, utils.AccountKey)
+	forgotPasswordIPRateLimit := utils.RateLimit("forgot-password-ip", 10, time.Hour, utils.IPKey)
+	forgotPasswordAccountRateLimit := utils.RateLimit("forgot-password-account", 3, time.Hour, utils.AccountKey)
+	registerRateLimit := utils.RateLimit("register-ip", 10, time.Hour, utils.IPKey)
+	loginCodeIPRateLimit := utils.RateLimit("login-code-ip", 10, time.Hour, utils.IPKey)
+	loginCodeAccountRateLimit := utils.RateLimit("login-code-account", 3, 15*time.Minute, utils.AccountKey)
+	dataExportRateLimit := utils.RateLimit("data-export", 3, 24*time.Hour, utils.UserOrIPKey)
+	accountDeletionRateLimit := utils.RateLimit("account-deletion", 5, time.Hour, utils.UserOrIPKey)
+	loginNonceRateLimit := utils.RateLimit("login-nonce", 30, 15*time.Minute, utils.IPKey)
+	twoFactorRateLimit := utils.RateLimit("two-factor", 5, 5*time.Minute, utils.TwoFactorChallengeKey)
+
+	locations := app.Party("/api/location", optionalAccessTokenVerifierMiddleware, locationRateLimit)
 	{
-		location.Get("/autocomplete", routes.Autocomplete)
-		location.Get("/search", routes.Search)
+		locations.Get("/autocomplete", routes.Autocomplete)
+		locations.Get("/search", routes.Search)
 	}
 	user := app.Party("/api/user")
 	{
-		user.Post("/register", routes.Register)
-		user.Post("/login", routes.Login)
+		user.Post("/register", registerRateLimit, routes.Register)
+		user.Post("/login", loginIPRateLimit, loginAccountRateLimit, routes.Login)
+		user.Post("/login/email", loginCodeIPRateLimit, loginCodeAccountRateLimit, routes.RequestLoginCode)
+		user.Post("/login/email/verify", loginIPRateLimit, loginAccountRateLimit, routes.VerifyLoginCode)
+		user.Post("/login/2fa", twoFactorChallengeVerifierMiddleware, twoFactorRateLimit, routes.LoginTwoFactor)
+		user.Post("/login/2fa/enroll", twoFactorEnrollmentVerifierMiddleware, routes.EnrollTwoFactor)
+		user.Post("/login/2fa/enroll/confirm", twoFactorEnrollmentVerifierMiddleware, twoFactorRateLimit, routes.ConfirmTwoFactor)
+		user.Post("/logout", accessTokenVerifierMiddleware, routes.Logout)
+		user.Get("/sessions", accessTokenVerifierMiddleware, routes.GetSessions)
+		user.Delete("/sessions", accessTokenVerifierMiddleware, routes.RevokeAllSessions)
+		user.Delete("/sessions/{id}", accessTokenVerifierMiddleware, routes.RevokeSession)
+		user.Post("/2fa/enroll", accessTokenVerifierMiddleware, routes.EnrollTwoFactor)
+		user.Post("/2fa/confirm", accessTokenVerifierMiddleware, routes.ConfirmTwoFactor)
+		user.Post("/2fa/disable", accessTokenVerifierMiddleware, routes.DisableTwoFactor)
+		user.Post("/2fa/recovery-codes", accessTokenVerifierMiddleware, routes.RegenerateRecoveryCodes)
 		user.Post("/facebook", routes.FacebookLoginOrSignUp)
+		user.Post("/nonce", loginNonceRateLimit, routes.CreateLoginNonce)
 		user.Post("/google", routes.GoogleLoginOrSignUp)
 		user.Post("/apple", routes.AppleLoginOrSignUp)
-		user.Post("/forgotpassword", routes.ForgotPassword)
+		user.Get("/identities", accessTokenVerifierMiddleware, routes.GetIdentities)
+		user.Post("/identities/merge", accessTokenVerifierMiddleware, routes.MergeIdentity)
+		user.Post("/identities/{provider}", accessTokenVerifierMiddleware, routes.LinkIdentity)
+		user.Delete("/identities/{id:uint}", accessTokenVerifierMiddleware, routes.UnlinkIdentity)
+		user.Post("/password", accessTokenVerifierMiddleware, routes.SetPassword)
+		user.Post("/account/export", accessTokenVerifierMiddleware, dataExportRateLimit, routes.RequestDataExport)
+		user.Get("/account/exports", accessTokenVerifierMiddleware, routes.GetDataExports)
+		user.Get("/account/exports/{id:uint}", accessTokenVerifierMiddleware, routes.DownloadDataExport)
+		user.Post("/account/deletion", accessTokenVerifierMiddleware, accountDeletionRateLimit, routes.RequestAccountDeletion)
+		user.Delete("/account/deletion", accessTokenVerifierMiddleware, routes.CancelAccountDeletion)
+		user.Post("/forgotpassword", forgotPasswordIPRateLimit, forgotPasswordAccountRateLimit, routes.ForgotPassword)
 		user.Post("/resetpassword", resetTokenVerifierMiddleware, routes.ResetPassword)
+		user.Get("/verify", verifyTokenVerifierMiddleware, routes.VerifyEmail)
+		user.Post("/verify/resend", accessTokenVerifierMiddleware, verificationResendRateLimit, routes.ResendVerificationEmail)
 		user.Get("/{id}/properties/saved", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetUserSavedProperties)
 		user.Patch("/{id}/properties/saved", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.AlterUserSavedProperties)
 		user.Patch("/{id}/pushtoken", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.AlterPushToken)
 		user.Patch("/{id}/settings/notifications", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.AllowsNotifications)
+		user.Patch("/{id}/settings/locale", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.UpdateLocale)
+		user.Patch("/{id}/profile", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.UpdateProfile)
+		user.Put("/{id}/profile/avatar", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.UpdateAvatar)
+		user.Delete("/{id}/profile/avatar", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.DeleteAvatar)
+		user.Get("/{id}/public", routes.GetPublicProfile)
 		user.Get("/{id}/properties/contacted", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetUserContactedProperties)
 	}
 	property := app.Party("/api/property")
 	{
-		property.Post("/", routes.CreateProperty)
-		property.Get("/{id}", routes.GetProperty)
+		property.Post("/", accessTokenVerifierMiddleware, utils.RequirePermission(models.PermCreateProperty), utils.RequireVerified(utils.RestrictCreateProperty), routes.CreateProperty)
+		property.Get("/{id}", optionalAccessTokenVerifierMiddleware, routes.GetProperty)
+		property.Get("/{id}/commute", optionalAccessTokenVerifierMiddleware, locationRateLimit, routes.GetPropertyCommute)
 		property.Get("/userid/{id}", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetPropertiesByUserID)
 		property.Delete("/{id}", accessTokenVerifierMiddleware, routes.DeleteProperty)
 		property.Patch("/update/{id}", accessTokenVerifierMiddleware, routes.UpdateProperty)
-		property.Post("/search", routes.GetPropertiesByBoundingBox)
+		property.Post("/search", optionalAccessTokenVerifierMiddleware, routes.GetPropertiesByBoundingBox)
 	}
 	apartment := app.Party("/api/apartment")
 	{
@@ -88,17 +190,79 @@ func main() {
 	}
 	review := app.Party("/api/review")
 	{
+		review.Get("/property/{id}", optionalAccessTokenVerifierMiddleware, routes.GetPropertyReviews)
 		review.Post("/property/{id}", accessTokenVerifierMiddleware, routes.CreateReview)
+		review.Patch("/{id}", accessTokenVerifierMiddleware, routes.UpdateReview)
+		review.Delete("/{id}", accessTokenVerifierMiddleware, routes.DeleteReview)
+		review.Get("/{id}/history", accessTokenVerifierMiddleware, routes.GetReviewHistory)
+		review.Put("/{id}/response", accessTokenVerifierMiddleware, routes.RespondToReview)
+		review.Delete("/{id}/response", accessTokenVerifierMiddleware, routes.DeleteReviewResponse)
+		review.Post("/{id}/report", accessTokenVerifierMiddleware, routes.ReportReview)
+		review.Post("/{id}/helpful", accessTokenVerifierMiddleware, routes.MarkReviewHelpful)
+		review.Delete("/{id}/helpful", accessTokenVerifierMiddleware, routes.UnmarkReviewHelpful)
+	}
+	reviewModeration := app.Party("/api/moderation/reviews", accessTokenVerifierMiddleware, utils.RequirePermission(models.PermModerateReviews))
+	{
+		reviewModeration.Get("/", routes.GetReviewReports)
+		reviewModeration.Post("/{id}/dismiss", routes.DismissReviewReports)
+		reviewModeration.Post("/{id}/remove", routes.RemoveReview)
 	}
 	conversation := app.Party("/api/conversation")
 	{
-		conversation.Post("/", accessTokenVerifierMiddleware, routes.CreateConversation)
+		conversation.Post("/", accessTokenVerifierMiddleware, utils.RequireVerified(utils.RestrictMessaging), routes.CreateConversation)
+		conversation.Get("/unread", accessTokenVerifierMiddleware, routes.GetUnreadCount)
 		conversation.Get("/{id}", accessTokenVerifierMiddleware, routes.GetConversationByID)
+		conversation.Get("/{id}/messages", accessTokenVerifierMiddleware, routes.GetConversationMessages)
+		conversation.Get("/{id}/attachments/{attachmentID:uint}", accessTokenVerifierMiddleware, routes.GetAttachmentURL)
+		conversation.Post("/{id}/read", accessTokenVerifierMiddleware, routes.MarkConversationRead)
 		conversation.Get("/user/{id}", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetConversationsByUserID)
 	}
 	messages := app.Party("/api/messages")
 	{
-		messages.Post("/", accessTokenVerifierMiddleware, routes.CreateMessage)
+		// Room for five base64-encoded 10 MB attachments.
+		messages.Post("/", iris.LimitRequestBodySize(70<<20), accessTokenVerifierMiddleware, utils.RequireVerified(utils.RestrictMessaging), routes.CreateMessage)
+	}
+	moderation := app.Party("/api/moderation", accessTokenVerifierMiddleware, utils.RequirePermission(models.PermModerateListings))
+	{
+		moderation.Get("/queue", routes.GetModerationQueue)
+		moderation.Get("/{id}", routes.GetModerationItem)
+		moderation.Post("/{id}/approve", routes.ApproveListing)
+		moderation.Post("/{id}/reject", routes.RejectListing)
+	}
+	admin := app.Party("/api/admin", accessTokenVerifierMiddleware, utils.RequirePermission(models.PermManageUsers))
+	{
+		admin.Get("/users", routes.AdminSearchUsers)
+		admin.Get("/users/{id}", routes.AdminGetUser)
+		admin.Get("/users/{id}/properties", routes.AdminGetUserProperties)
+		admin.Get("/users/{id}/conversations", routes.AdminGetUserConversations)
+		admin.Get("/users/{id}/reservations", routes.AdminGetUserReservations)
+		admin.Post("/users/{id}/suspend", routes.AdminSuspendUser)
+		admin.Post("/users/{id}/reactivate", routes.AdminReactivateUser)
+		admin.Post("/users/{id}/logout", routes.AdminForceLogout)
+		admin.Patch("/users/{id}/membership", routes.AdminUpdateMembershipTier)
+		admin.Patch("/users/{id}/2fa", routes.AdminSetTwoFactorRequired)
+		admin.Delete("/users/{id}/2fa", routes.AdminResetTwoFactor)
+		admin.Delete("/properties/{id}", routes.AdminTakeDownProperty)
+		admin.Delete("/reviews/{id}", routes.AdminDeleteReview)
+		admin.Get("/audit", routes.AdminGetAuditLog)
+	}
+	promotionAnalytics := utils.RequireEntitlement("promotion analytics", func(e models.Entitlements) bool {
+		return e.AnalyticsAccess
+	})
+	promotion := app.Party("/api/promotion")
+	{
+		promotion.Post("/", accessTokenVerifierMiddleware, routes.CreatePromotion)
+		promotion.Get("/", accessTokenVerifierMiddleware, promotionAnalytics, routes.GetPromotions)
+		promotion.Get("/{id}", accessTokenVerifierMiddleware, promotionAnalytics, routes.GetPromotion)
+		promotion.Delete("/{id}", accessTokenVerifierMiddleware, routes.CancelPromotion)
+		promotion.Post("/{id}/click", optionalAccessTokenVerifierMiddleware, routes.RecordPromotionClick)
+	}
+	billingParty := app.Party("/api/billing")
+	{
+		billingParty.Get("/subscription", accessTokenVerifierMiddleware, routes.GetSubscription)
+		billingParty.Post("/checkout", accessTokenVerifierMiddleware, routes.CreateCheckout)
+		billingParty.Post("/cancel", accessTokenVerifierMiddleware, routes.CancelSubscription)
+		billingParty.Post("/webhook", routes.BillingWebhook)
 	}
 	notifications := app.Party("/api/notifications")
 	{
@@ -108,11 +272,11 @@ func main() {
 
 	reservation := app.Party("/api/reservation")
     {
-        reservation.Post("/", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.CreateReservation)
-        reservation.Get("/{id}", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetReservation)
-        reservation.Get("/user", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.GetReservationsByUserID)
-        reservation.Put("/{id}", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.UpdateReservation)
-        reservation.Delete("/{id}", accessTokenVerifierMiddleware, utils.UserIDMiddleware, routes.DeleteReservation)
+        reservation.Post("/", accessTokenVerifierMiddleware, utils.RequirePermission(models.PermCreateReservation), utils.RequireVerified(utils.RestrictReservations), utils.CurrentUserMiddleware, routes.CreateReservation)
+        reservation.Get("/{id}", accessTokenVerifierMiddleware, routes.GetReservation)
+        reservation.Get("/user", accessTokenVerifierMiddleware, utils.CurrentUserMiddleware, routes.GetReservationsByUserID)
+        reservation.Put("/{id}", accessTokenVerifierMiddleware, routes.UpdateReservation)
+        reservation.Delete("/{id}", accessTokenVerifierMiddleware, routes.DeleteReservation)
     }
 	app.Listen(":4000")
 
@@ -14,8 +14,8 @@ type Apartment struct {
 	Bedrooms    int            `json:"bedrooms"`
 	Bathrooms   float32        `json:"bathrooms"`
 	SqFt        int            `json:"sqFt"`
-	Rent        float32        `json:"rent"`
-	Deposit     float32        `json:"deposit"`
+	Rent        int64          `json:"rent"`    // minor units of the property currency
+	Deposit     int64          `json:"deposit"` // minor units of the property currency
 	LeaseLength string         `json:"leaseLength"`
 	AvailableOn time.Time      `json:"availableOn"`
 	Active      *bool          `json:"active"`
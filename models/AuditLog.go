@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// AuditLog is an append-only record of an administrative action. It has no
+// UpdatedAt or DeletedAt on purpose; the database rejects changes to it.
+type AuditLog struct {
+	ID         uint           `json:"ID" gorm:"primarykey"`
+	CreatedAt  time.Time      `json:"createdAt" gorm:"index"`
+	ActorID    uint           `json:"actorID" gorm:"index"`
+	Action     string         `json:"action"`
+	TargetType string         `json:"targetType" gorm:"index:idx_audit_target"`
+	TargetID   uint           `json:"targetID" gorm:"index:idx_audit_target"`
+	Details    datatypes.JSON `json:"details"`
+	IP         string         `json:"ip"`
+}
@@ -8,4 +8,17 @@ type Conversation struct {
 	OwnerID    uint      `json:"ownerID"`
 	PropertyID uint      `json:"propertyID"`
 	Messages   []Message `json:"messages"`
+	// The last message each participant has read. Messages after it from the
+	// other participant are unread.
+	TenantLastReadMessageID uint `json:"tenantLastReadMessageID" gorm:"not null;default:0"`
+	OwnerLastReadMessageID  uint `json:"ownerLastReadMessageID" gorm:"not null;default:0"`
+}
+
+// LastReadColumn is the column holding userID's read marker.
+func (conversation *Conversation) LastReadColumn(userID uint) string {
+	if userID == conversation.TenantID {
+		return "tenant_last_read_message_id"
+	}
+
+	return "owner_last_read_message_id"
 }
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type DataExportStatus string
+
+const (
+	DataExportPending DataExportStatus = "pending"
+	DataExportReady   DataExportStatus = "ready"
+	DataExportFailed  DataExportStatus = "failed"
+)
+
+// DataExport is a ZIP archive of everything stored about a user, built in
+// the background at their request and kept until ExpiresAt.
+type DataExport struct {
+	gorm.Model
+	UserID    uint             `json:"userID" gorm:"index"`
+	Status    DataExportStatus `json:"status"`
+	Archive   []byte           `json:"-"`
+	Size      int              `json:"size"`
+	ExpiresAt *time.Time       `json:"expiresAt"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "gorm.io/gorm"
+
+const (
+	FacebookProvider = "Facebook"
+	GoogleProvider   = "Google"
+	AppleProvider    = "Apple"
+)
+
+// Identity links a user to an account at a social login provider. A user can
+// have any number of identities as well as a password.
+type Identity struct {
+	gorm.Model
+	UserID   uint   `json:"userID" gorm:"index"`
+	Provider string `json:"provider" gorm:"uniqueIndex:idx_identity_provider_subject"`
+	Subject  string `json:"-" gorm:"uniqueIndex:idx_identity_provider_subject"` // the provider's user ID
+	Email    string `json:"email"`
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Unlimited marks an entitlement without a cap.
+const Unlimited = -1
+
+// Entitlements are what a membership tier allows. They are defined here and
+// nowhere else; handlers check them through utils.
+type Entitlements struct {
+	MaxActiveListings int  `json:"maxActiveListings"`
+	FeaturedPlacement bool `json:"featuredPlacement"`
+	AnalyticsAccess   bool `json:"analyticsAccess"`
+	MaxSavedSearches  int  `json:"maxSavedSearches"`
+	PromotionSlots    int  `json:"promotionSlots"`
+}
+
+var tierEntitlements = map[MembershipTier]Entitlements{
+	FreeTier: {
+		MaxActiveListings: 1,
+		MaxSavedSearches:  1,
+	},
+	PremiumTier: {
+		MaxActiveListings: 5,
+		FeaturedPlacement: true,
+		MaxSavedSearches:  10,
+		PromotionSlots:    1,
+	},
+	ProTier: {
+		MaxActiveListings: Unlimited,
+		FeaturedPlacement: true,
+		AnalyticsAccess:   true,
+		MaxSavedSearches:  Unlimited,
+		PromotionSlots:    5,
+	},
+}
+
+var tierRanks = map[MembershipTier]int{
+	FreeTier:    0,
+	PremiumTier: 1,
+	ProTier:     2,
+}
+
+// Entitlements returns what the tier allows, treating unknown tiers as Free.
+func (t MembershipTier) Entitlements() Entitlements {
+	if entitlements, ok := tierEntitlements[t]; ok {
+		return entitlements
+	}
+
+	return tierEntitlements[FreeTier]
+}
+
+func (t MembershipTier) IsValid() bool {
+	_, ok := tierEntitlements[t]
+	return ok
+}
+
+// Below reports whether t is a lower tier than other.
+func (t MembershipTier) Below(other MembershipTier) bool {
+	return tierRanks[t] < tierRanks[other]
+}
+
+// WithinLimit reports whether count is below a limit that may be Unlimited.
+func WithinLimit(count int64, limit int) bool {
+	return limit == Unlimited || count < int64(limit)
+}
+
+type SubscriptionStatus string
+
+const (
+	SubscriptionPending  SubscriptionStatus = "pending"
+	SubscriptionActive   SubscriptionStatus = "active"
+	SubscriptionPastDue  SubscriptionStatus = "past_due"
+	SubscriptionCanceled SubscriptionStatus = "canceled"
+)
+
+type Subscription struct {
+	gorm.Model
+	UserID            uint               `json:"userID" gorm:"index"`
+	Provider          string             `json:"provider"`
+	ExternalID        string             `json:"externalID" gorm:"index"`
+	CustomerID        string             `json:"customerID"`
+	Tier              MembershipTier     `json:"tier"`
+	Status            SubscriptionStatus `json:"status"`
+	CurrentPeriodEnd  *time.Time         `json:"currentPeriodEnd"`
+	CancelAtPeriodEnd bool               `json:"cancelAtPeriodEnd"`
+}
@@ -7,7 +7,26 @@ import (
 type Message struct {
 	gorm.Model
 	ConversationID uint
-	SenderID       uint   `json:"senderID"`
-	ReceiverID     uint   `json:"receiverID"`
-	Text           string `json:"text"`
+	SenderID       uint                `json:"senderID"`
+	ReceiverID     uint                `json:"receiverID"`
+	Text           string              `json:"text"`
+	Attachments    []MessageAttachment `json:"attachments"`
+}
+
+// MessageAttachment is a file sent in a message. It is stored privately and
+// only the conversation's participants can get a download URL for it.
+type MessageAttachment struct {
+	gorm.Model
+	MessageID         uint   `json:"messageID" gorm:"index"`
+	ConversationID    uint   `json:"conversationID" gorm:"index"`
+	FileName          string `json:"fileName"`
+	ContentType       string `json:"contentType"`
+	Size              int64  `json:"size"`
+	Width             int    `json:"width,omitempty"` // images only
+	Height            int    `json:"height,omitempty"`
+	HasThumbnail      bool   `json:"hasThumbnail"`
+	ResourceType      string `json:"-"`
+	PublicID          string `json:"-"`
+	Format            string `json:"-"`
+	ThumbnailPublicID string `json:"-"`
 }
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+type ModerationStatus string
+
+const (
+	ModerationOpen     ModerationStatus = "open"
+	ModerationApproved ModerationStatus = "approved"
+	ModerationRejected ModerationStatus = "rejected"
+)
+
+type ModerationItem struct {
+	gorm.Model
+	PropertyID  uint             `json:"propertyID" gorm:"index"`
+	Property    Property         `json:"property"`
+	Status      ModerationStatus `json:"status" gorm:"default:open;index"`
+	Checks      datatypes.JSON   `json:"checks"` // []ModerationCheck from the automated pre-checks
+	ModeratorID *uint            `json:"moderatorID"`
+	Reason      string           `json:"reason"`
+	DecidedAt   *time.Time       `json:"decidedAt"`
+}
+
+type ModerationCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
@@ -0,0 +1,59 @@
+package models
+
+import "encoding/json"
+
+// Profile fields whose visibility the user controls. Names are always
+// public.
+const (
+	ProfileAvatar       = "avatar"
+	ProfileBio          = "bio"
+	ProfilePhone        = "phone"
+	ProfileLanguages    = "languages"
+	ProfileResponseRate = "responseRate"
+	ProfileMemberSince  = "memberSince"
+)
+
+// defaultProfileVisibility applies to fields the user hasn't chosen for.
+// Only the phone number is private unless the user shares it.
+var defaultProfileVisibility = map[string]bool{
+	ProfileAvatar:       true,
+	ProfileBio:          true,
+	ProfilePhone:        false,
+	ProfileLanguages:    true,
+	ProfileResponseRate: true,
+	ProfileMemberSince:  true,
+}
+
+func IsProfileField(field string) bool {
+	_, ok := defaultProfileVisibility[field]
+	return ok
+}
+
+// Visibility returns whether each profile field is public, applying the
+// defaults to fields the user hasn't set.
+func (u User) Visibility() map[string]bool {
+	visibility := make(map[string]bool, len(defaultProfileVisibility))
+	for field, public := range defaultProfileVisibility {
+		visibility[field] = public
+	}
+
+	var chosen map[string]bool
+	if len(u.ProfileVisibility) > 0 && json.Unmarshal(u.ProfileVisibility, &chosen) == nil {
+		for field, public := range chosen {
+			if IsProfileField(field) {
+				visibility[field] = public
+			}
+		}
+	}
+
+	return visibility
+}
+
+func (u User) LanguageList() []string {
+	languages := []string{}
+	if len(u.Languages) > 0 {
+		json.Unmarshal(u.Languages, &languages)
+	}
+
+	return languages
+}
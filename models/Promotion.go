@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Promotion places a property in search results as a sponsored listing
+// while it runs. The target area is a bounding box; searches overlapping it
+// are eligible to show the promotion.
+type Promotion struct {
+	gorm.Model
+	PropertyID  uint       `json:"propertyID" gorm:"index"`
+	Property    Property   `json:"property,omitempty"`
+	UserID      uint       `json:"userID" gorm:"index"`
+	StartsAt    time.Time  `json:"startsAt" gorm:"index"`
+	EndsAt      time.Time  `json:"endsAt" gorm:"index"`
+	LatLow      float32    `json:"latLow"`
+	LatHigh     float32    `json:"latHigh"`
+	LngLow      float32    `json:"lngLow"`
+	LngHigh     float32    `json:"lngHigh"`
+	Impressions int64      `json:"impressions" gorm:"default:0"`
+	Clicks      int64      `json:"clicks" gorm:"default:0"`
+	CanceledAt  *time.Time `json:"canceledAt"`
+}
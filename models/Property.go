@@ -5,6 +5,15 @@ import (
 	"gorm.io/gorm"
 )
 
+type ListingStatus string
+
+const (
+	ListingPendingReview ListingStatus = "pending_review"
+	ListingApproved      ListingStatus = "approved"
+	ListingRejected      ListingStatus = "rejected"
+	ListingTakenDown     ListingStatus = "taken_down"
+)
+
 type Property struct {
 	gorm.Model
 	UnitType          string         `json:"unitType"`
@@ -12,15 +21,20 @@ type Property struct {
 	Street            string         `json:"street"`
 	City              string         `json:"city"`
 	State             string         `json:"state"`
-	Zip               int            `json:"zip"`
+	Zip               string         `json:"zip"`
+	Country           string         `json:"country" gorm:"default:US"`   // ISO 3166-1 alpha-2
+	Currency          string         `json:"currency" gorm:"default:USD"` // ISO 4217, amounts are in its minor units
 	Lat               float32        `json:"lat"`
 	Lng               float32        `json:"lng"`
+	NormalizedAddress string         `json:"normalizedAddress"`
+	GeocodeConfidence float32        `json:"geocodeConfidence"`
+	AddressMismatch   bool           `json:"addressMismatch"`
 	BedroomLow        int            `json:"bedroomLow"`   // calculate based off apartments
 	BedroomHigh       int            `json:"bedroomHigh"`  // calculate based off apartments
 	BathroomLow       float32        `json:"bathroomLow"`  // calculate based off apartments
 	BathroomHigh      float32        `json:"bathroomHigh"` // calculate based off apartments
-	RentLow           float32        `json:"rentLow"`      // calculate based off apartments
-	RentHigh          float32        `json:"rentHigh"`     // calculate based off apartments
+	RentLow           int64          `json:"rentLow"`      // calculate based off apartments
+	RentHigh          int64          `json:"rentHigh"`     // calculate based off apartments
 	UserID            uint           `json:"userID"`
 	Name              string         `json:"name"`
 	Amenities         datatypes.JSON `json:"amenities"`
@@ -32,13 +46,17 @@ type Property struct {
 	LastName          string         `json:"lastName"`
 	LaundryType       string         `json:"laundryType"`
 	OnMarket          *bool          `json:"onMarket"`
-	ParkingFee        float32        `json:"parkingFee"`
+	Status            ListingStatus  `json:"status" gorm:"default:pending_review;index"`
+	RejectionReason   string         `json:"rejectionReason"`
+	ParkingFee        int64          `json:"parkingFee"`
 	PetsAllowed       string         `json:"petsAllowed"`
 	CountryCode       string         `json:"countryCode"`
 	CallingCode       string         `json:"callingCode"`
 	PhoneNumber       string         `json:"phoneNumber"`
 	Website           string         `json:"website"`
 	Stars             float32        `json:"stars"`
+	ReviewCount       int64          `json:"reviewCount" gorm:"not null;default:0"`
+	StarSum           int64          `json:"-" gorm:"not null;default:0"` // kept with ReviewCount by the ratings package
 	Apartments        []Apartment    `json:"apartments"`
 	Reviews           []Review       `json:"reviews"`
 }
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a one-time code that stands in for a TOTP code when the
+// user has lost their authenticator. Only a bcrypt hash is stored.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint       `json:"userID" gorm:"index"`
+	CodeHash string     `json:"-"`
+	UsedAt   *time.Time `json:"usedAt"`
+}
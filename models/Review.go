@@ -1,12 +1,76 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
 
+	"gorm.io/gorm"
+)
+
+type ReviewStatus string
+
+const (
+	ReviewPublished ReviewStatus = "published"
+	ReviewHidden    ReviewStatus = "hidden" // held for moderation after reports
+	ReviewRemoved   ReviewStatus = "removed"
+)
+
+// Review is one user's review of a property; each user has at most one per
+// property.
 type Review struct {
 	gorm.Model
-	UserID     uint   `json:"userID"`
-	PropertyID uint   `json:"propertyID"`
-	Title      string `json:"title"`
-	Body       string `json:"body"`
-	Stars      int    `json:"stars"`
+	UserID           uint         `json:"userID" gorm:"uniqueIndex:idx_review_author_property,where:deleted_at IS NULL"`
+	PropertyID       uint         `json:"propertyID" gorm:"index;uniqueIndex:idx_review_author_property,where:deleted_at IS NULL"`
+	Title            string       `json:"title"`
+	Body             string       `json:"body"`
+	Stars            int          `json:"stars"`
+	Cleanliness      *int         `json:"cleanliness"` // optional sub-ratings, 1-5
+	Communication    *int         `json:"communication"`
+	Value            *int         `json:"value"`
+	Location         *int         `json:"location"`
+	HelpfulCount     int          `json:"helpfulCount" gorm:"default:0"`
+	Status           ReviewStatus `json:"status" gorm:"default:published;index"`
+	VerifiedStay     bool         `json:"verifiedStay"` // the author had a completed reservation
+	EditedAt         *time.Time   `json:"editedAt"`
+	OwnerResponse    string       `json:"ownerResponse"`
+	OwnerRespondedAt *time.Time   `json:"ownerRespondedAt"`
+}
+
+// ReviewRevision keeps a review's content as it was before an edit.
+type ReviewRevision struct {
+	gorm.Model
+	ReviewID uint   `json:"reviewID" gorm:"index"`
+	Title    string `json:"title"`
+	Body     string `json:"body"`
+	Stars    int    `json:"stars"`
+}
+
+// ReviewHelpfulVote is a user marking someone else's review helpful.
+type ReviewHelpfulVote struct {
+	gorm.Model
+	ReviewID uint `json:"reviewID" gorm:"uniqueIndex:idx_review_helpful_voter"`
+	UserID   uint `json:"userID" gorm:"uniqueIndex:idx_review_helpful_voter"`
+}
+
+type ReviewReportReason string
+
+const (
+	ReportSpam       ReviewReportReason = "spam"
+	ReportOffensive  ReviewReportReason = "offensive"
+	ReportIrrelevant ReviewReportReason = "irrelevant"
+	ReportFake       ReviewReportReason = "fake"
+	ReportOther      ReviewReportReason = "other"
+)
+
+// ReviewReport is a user flagging a review for moderators. Reports share the
+// moderation statuses: approved keeps the review, rejected removes it.
+type ReviewReport struct {
+	gorm.Model
+	ReviewID    uint               `json:"reviewID" gorm:"uniqueIndex:idx_review_report_reporter"`
+	Review      Review             `json:"review"`
+	ReporterID  uint               `json:"reporterID" gorm:"uniqueIndex:idx_review_report_reporter"`
+	Reason      ReviewReportReason `json:"reason"`
+	Detail      string             `json:"detail"`
+	Status      ModerationStatus   `json:"status" gorm:"default:open;index"`
+	ModeratorID *uint              `json:"moderatorID"`
+	DecidedAt   *time.Time         `json:"decidedAt"`
 }
@@ -0,0 +1,57 @@
+package models
+
+type Role string
+
+const (
+	TenantRole Role = "tenant"
+	OwnerRole  Role = "owner"
+	StaffRole  Role = "staff"
+	AdminRole  Role = "admin"
+)
+
+type Permission string
+
+const (
+	PermCreateProperty       Permission = "properties:create"
+	PermManageOwnProperties  Permission = "properties:manage_own"
+	PermManageAnyProperty    Permission = "properties:manage_any"
+	PermCreateReservation    Permission = "reservations:create"
+	PermViewAnyReservation   Permission = "reservations:view_any"
+	PermManageAnyReservation Permission = "reservations:manage_any"
+	PermModerateListings     Permission = "listings:moderate"
+	PermModerateReviews      Permission = "reviews:moderate"
+	PermManageUsers          Permission = "users:manage"
+)
+
+var tenantPermissions = []Permission{
+	PermCreateProperty,
+	PermManageOwnProperties,
+	PermCreateReservation,
+}
+
+var staffPermissions = append(append([]Permission{}, tenantPermissions...),
+	PermManageAnyProperty,
+	PermViewAnyReservation,
+	PermModerateListings,
+	PermModerateReviews,
+)
+
+var rolePermissions = map[Role][]Permission{
+	TenantRole: tenantPermissions,
+	OwnerRole:  tenantPermissions,
+	StaffRole:  staffPermissions,
+	AdminRole: append(append([]Permission{}, staffPermissions...),
+		PermManageAnyReservation,
+		PermManageUsers,
+	),
+}
+
+func (r Role) IsValid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Permissions returns the permissions granted by the role.
+func (r Role) Permissions() []Permission {
+	return rolePermissions[r]
+}
@@ -23,6 +23,9 @@
 package models
 
 import (
+	"encoding/json"
+	"time"
+
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
@@ -47,5 +50,38 @@ type User struct {
     PushTokens          datatypes.JSON `json:"pushTokens"`
     AllowsNotifications *bool          `json:"allowsNotifications"`
     IsVerified          *bool          `json:"isVerified"`
+    Locale              string         `json:"locale" gorm:"default:en"`
+    AvatarURL           string         `json:"avatarURL"`
+    Bio                 string         `json:"bio"`
+    Phone               string         `json:"phone"`
+    Languages           datatypes.JSON `json:"languages"`         // []string of language tags
+    ProfileVisibility   datatypes.JSON `json:"profileVisibility"` // profile field -> shown on the public profile
     MembershipTier      MembershipTier `json:"membershipTier" gorm:"type:membership_tier;default:'Free'"`
+    Role                Role           `json:"role" gorm:"default:tenant"`
+    Permissions         datatypes.JSON `json:"permissions"` // []Permission granted on top of the role
+    SuspendedAt         *time.Time     `json:"suspendedAt"`
+    SuspensionReason    string         `json:"suspensionReason"`
+    TwoFactorEnabled    bool           `json:"twoFactorEnabled"`
+    TwoFactorRequired   bool           `json:"twoFactorRequired"` // set by an admin
+    TwoFactorSecret     string         `json:"-"`                 // TOTP secret, sealed with AES-GCM
+    TwoFactorLastStep   int64          `json:"-"`                 // last TOTP step accepted, to stop replays
+    DeletionScheduledFor *time.Time    `json:"deletionScheduledFor"` // set while a requested deletion is cooling off
+}
+
+// AllPermissions returns the permissions of the user's role together with
+// any granted to the user directly.
+func (u User) AllPermissions() []Permission {
+    role := u.Role
+    if role == "" {
+        role = TenantRole
+    }
+
+    permissions := append([]Permission{}, role.Permissions()...)
+
+    var granted []Permission
+    if len(u.Permissions) > 0 && json.Unmarshal(u.Permissions, &granted) == nil {
+        permissions = append(permissions, granted...)
+    }
+
+    return permissions
 }
\ No newline at end of file
@@ -15,7 +15,8 @@ type Reservation struct {
     Property    Property  `json:"property"`
     StartDate   time.Time `json:"startDate"`
     EndDate     time.Time `json:"endDate"`
-    TotalPrice  float64   `json:"totalPrice"`
+    TotalPrice  int64     `json:"totalPrice"` // minor units of Currency
+    Currency    string    `json:"currency"`
     Status      string    `json:"status"` // "pending", "confirmed", "cancelled"
     PaymentStatus string  `json:"paymentStatus"` // "pending", "paid", "refunded"
     GuestCount  int       `json:"guestCount"`
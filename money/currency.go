@@ -0,0 +1,85 @@
+package money
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+type Currency struct {
+	Code     string
+	Exponent int
+	Symbol   string
+}
+
+var currencies = map[string]Currency{
+	"USD": {Code: "USD", Exponent: 2, Symbol: "$"},
+	"CAD": {Code: "CAD", Exponent: 2, Symbol: "CA$"},
+	"MXN": {Code: "MXN", Exponent: 2, Symbol: "MX$"},
+	"GBP": {Code: "GBP", Exponent: 2, Symbol: "£"},
+	"EUR": {Code: "EUR", Exponent: 2, Symbol: "€"},
+	"CHF": {Code: "CHF", Exponent: 2, Symbol: "CHF "},
+	"SEK": {Code: "SEK", Exponent: 2, Symbol: "kr "},
+	"AUD": {Code: "AUD", Exponent: 2, Symbol: "A$"},
+	"NZD": {Code: "NZD", Exponent: 2, Symbol: "NZ$"},
+	"INR": {Code: "INR", Exponent: 2, Symbol: "₹"},
+	"BRL": {Code: "BRL", Exponent: 2, Symbol: "R$"},
+	"JPY": {Code: "JPY", Exponent: 0, Symbol: "¥"},
+	"KRW": {Code: "KRW", Exponent: 0, Symbol: "₩"},
+}
+
+func Lookup(code string) (Currency, bool) {
+	currency, ok := currencies[strings.ToUpper(code)]
+	return currency, ok
+}
+
+func IsSupported(code string) bool {
+	_, ok := Lookup(code)
+	return ok
+}
+
+// ToMinor converts an amount in major units (e.g. dollars) to minor units
+// (e.g. cents) for the given currency.
+func ToMinor(major float64, code string) int64 {
+	currency, _ := Lookup(code)
+	return int64(math.Round(major * math.Pow10(currency.Exponent)))
+}
+
+// Format renders an amount of minor units with the currency symbol and
+// thousands separators, e.g. 120050 USD is "$1,200.50".
+func Format(amount int64, code string) string {
+	currency, ok := Lookup(code)
+	if !ok {
+		return strconv.FormatInt(amount, 10) + " " + code
+	}
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	unit := int64(math.Pow10(currency.Exponent))
+	major := groupThousands(strconv.FormatInt(amount/unit, 10))
+
+	if currency.Exponent == 0 {
+		return sign + currency.Symbol + major
+	}
+
+	minor := strconv.FormatInt(amount%unit, 10)
+	minor = strings.Repeat("0", currency.Exponent-len(minor)) + minor
+
+	return sign + currency.Symbol + major + "." + minor
+}
+
+func groupThousands(digits string) string {
+	var grouped strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+
+	return grouped.String()
+}
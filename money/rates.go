@@ -0,0 +1,62 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"strings"
+)
+
+var ErrNoRate = errors.New("no exchange rate for currency")
+
+// Rates holds how many units of each currency one US dollar buys.
+type Rates map[string]float64
+
+// ExchangeRates is used to convert search results to the viewer's
+// currency. It is set by Initialize.
+var ExchangeRates = Rates{"USD": 1}
+
+// Initialize loads exchange rates from the JSON file named by
+// EXCHANGE_RATES_FILE, e.g. {"EUR": 0.92, "GBP": 0.79}.
+func Initialize() {
+	path := os.Getenv("EXCHANGE_RATES_FILE")
+	if path == "" {
+		return
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rates := Rates{"USD": 1}
+	if err := json.Unmarshal(body, &rates); err != nil {
+		log.Fatal(err)
+	}
+
+	ExchangeRates = rates
+}
+
+// Convert changes an amount of minor units from one currency to another.
+func (r Rates) Convert(amount int64, from string, to string) (int64, error) {
+	from = strings.ToUpper(from)
+	to = strings.ToUpper(to)
+	if from == to {
+		return amount, nil
+	}
+
+	fromCurrency, fromOk := Lookup(from)
+	toCurrency, toOk := Lookup(to)
+	fromRate, fromRateOk := r[from]
+	toRate, toRateOk := r[to]
+	if !fromOk || !toOk || !fromRateOk || !toRateOk || fromRate == 0 {
+		return 0, ErrNoRate
+	}
+
+	major := float64(amount) / math.Pow10(fromCurrency.Exponent)
+	converted := major / fromRate * toRate
+
+	return int64(math.Round(converted * math.Pow10(toCurrency.Exponent))), nil
+}
@@ -0,0 +1,255 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MicahParks/keyfunc"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrInvalidToken  = errors.New("invalid ID token")
+	ErrNonceMismatch = errors.New("ID token nonce does not match")
+	ErrNotConfigured = errors.New("OIDC provider has no client IDs configured")
+)
+
+// jwksRefreshInterval is how often signing keys are fetched in the
+// background. Tokens signed with a key we haven't seen trigger an earlier
+// refresh, at most once per jwksRefreshRateLimit.
+const (
+	jwksRefreshInterval  = time.Hour
+	jwksRefreshRateLimit = 5 * time.Minute
+)
+
+// Config describes an OpenID Connect provider whose ID tokens we accept.
+type Config struct {
+	Name      string
+	Issuers   []string
+	JWKSURL   string
+	ClientIDs []string // accepted audiences
+	// HashedNonce is set for providers that put the SHA-256 of the client's
+	// nonce in the token, as Sign in with Apple does.
+	HashedNonce bool
+}
+
+// Claims are the ID token claims the server uses.
+type Claims struct {
+	jwt.RegisteredClaims
+	Email         string       `json:"email"`
+	EmailVerified flexibleBool `json:"email_verified"`
+	Nonce         string       `json:"nonce"`
+	Name          string       `json:"name"`
+	GivenName     string       `json:"given_name"`
+	FamilyName    string       `json:"family_name"`
+}
+
+// Verifier checks ID tokens from one provider. Its signing keys are fetched
+// on first use and then cached and refreshed in the background.
+type Verifier struct {
+	config Config
+
+	mu   sync.Mutex
+	jwks *keyfunc.JWKS
+}
+
+func NewVerifier(config Config) *Verifier {
+	return &Verifier{config: config}
+}
+
+func (v *Verifier) Name() string {
+	return v.config.Name
+}
+
+// Verify checks the token's signature, issuer, audience and expiry, and that
+// it carries the nonce the client sent with its sign-in request. Tokens
+// without a nonce are rejected, so one taken from another app's sign-in
+// can't be replayed here.
+func (v *Verifier) Verify(rawToken string, nonce string) (*Claims, error) {
+	if len(v.config.ClientIDs) == 0 {
+		return nil, ErrNotConfigured
+	}
+
+	jwks, err := v.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "ES256"}))
+	token, err := parser.ParseWithClaims(rawToken, &claims, jwks.Keyfunc)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now, true) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+
+	if !v.validIssuer(claims.Issuer) {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+
+	if !v.validAudience(claims.Audience) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	if !v.validNonce(claims.Nonce, nonce) {
+		return nil, ErrNonceMismatch
+	}
+
+	return &claims, nil
+}
+
+func (v *Verifier) keys() (*keyfunc.JWKS, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.jwks != nil {
+		return v.jwks, nil
+	}
+
+	jwks, err := keyfunc.Get(v.config.JWKSURL, keyfunc.Options{
+		Client:            &http.Client{Timeout: 10 * time.Second},
+		RefreshInterval:   jwksRefreshInterval,
+		RefreshRateLimit:  jwksRefreshRateLimit,
+		RefreshUnknownKID: true,
+		RefreshErrorHandler: func(err error) {
+			log.Println("oidc:", v.config.Name, "JWKS refresh failed:", err)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v.jwks = jwks
+	return jwks, nil
+}
+
+func (v *Verifier) validIssuer(issuer string) bool {
+	for _, accepted := range v.config.Issuers {
+		if issuer == accepted {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *Verifier) validAudience(audience jwt.ClaimStrings) bool {
+	for _, clientID := range v.config.ClientIDs {
+		for _, aud := range audience {
+			if aud == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (v *Verifier) validNonce(tokenNonce string, nonce string) bool {
+	if tokenNonce == "" || nonce == "" {
+		return false
+	}
+
+	if v.config.HashedNonce {
+		hash := sha256.Sum256([]byte(nonce))
+		nonce = hex.EncodeToString(hash[:])
+	}
+
+	return subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) == 1
+}
+
+// flexibleBool accepts both JSON booleans and the "true"/"false" strings
+// Apple sends for some claims.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch value := value.(type) {
+	case bool:
+		*b = flexibleBool(value)
+	case string:
+		*b = flexibleBool(value == "true")
+	}
+
+	return nil
+}
+
+// Google and Apple verify sign-in ID tokens. They are set by Initialize.
+var (
+	Google *Verifier
+	Apple  *Verifier
+)
+
+// Initialize configures the Google and Apple verifiers from the client IDs
+// in GOOGLE_CLIENT_IDS and APPLE_CLIENT_IDS, both comma separated. With
+// OIDC_PROVIDER=fake both accept tokens from a local FakeProvider instead,
+// which anyone who can reach it can sign in through, so it is refused
+// unless APP_ENV=development.
+func Initialize() {
+	googleClientIDs := splitList(os.Getenv("GOOGLE_CLIENT_IDS"))
+	appleClientIDs := splitList(os.Getenv("APPLE_CLIENT_IDS"))
+
+	if os.Getenv("OIDC_PROVIDER") == "fake" {
+		if os.Getenv("APP_ENV") != "development" {
+			log.Panic("OIDC_PROVIDER=fake is only allowed with APP_ENV=development")
+		}
+
+		fake, err := StartFakeProvider(os.Getenv("OIDC_FAKE_ADDR"))
+		if err != nil {
+			log.Panic("Error starting fake OIDC provider: ", err)
+		}
+
+		Google = NewVerifier(fake.Config("Google", googleClientIDs))
+		Apple = NewVerifier(fake.Config("Apple", appleClientIDs))
+		log.Println("oidc provider: fake at", fake.Issuer)
+		return
+	}
+
+	Google = NewVerifier(Config{
+		Name:      "Google",
+		Issuers:   []string{"https://accounts.google.com", "accounts.google.com"},
+		JWKSURL:   "https://www.googleapis.com/oauth2/v3/certs",
+		ClientIDs: googleClientIDs,
+	})
+
+	Apple = NewVerifier(Config{
+		Name:        "Apple",
+		Issuers:     []string{"https://appleid.apple.com"},
+		JWKSURL:     "https://appleid.apple.com/auth/keys",
+		ClientIDs:   appleClientIDs,
+		HashedNonce: true,
+	})
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
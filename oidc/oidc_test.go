@@ -0,0 +1,173 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const testClientID = "habitat-test-client"
+
+func startFake(t *testing.T) *FakeProvider {
+	t.Helper()
+
+	fake, err := StartFakeProvider("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fake
+}
+
+func testClaims(nonce string) Claims {
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  "subject-1",
+			Audience: jwt.ClaimStrings{testClientID},
+		},
+		Email:         "tenant@example.com",
+		EmailVerified: true,
+		Nonce:         nonce,
+	}
+}
+
+func signed(t *testing.T, fake *FakeProvider, claims Claims) string {
+	t.Helper()
+
+	token, err := fake.SignIDToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestVerify(t *testing.T) {
+	fake := startFake(t)
+	verifier := NewVerifier(fake.Config("Google", []string{testClientID}))
+
+	expired := testClaims("nonce-1")
+	expired.IssuedAt = jwt.NewNumericDate(time.Now().Add(-2 * time.Hour))
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+
+	badIssuer := testClaims("nonce-1")
+	badIssuer.Issuer = "https://accounts.example.com"
+
+	badAudience := testClaims("nonce-1")
+	badAudience.Audience = jwt.ClaimStrings{"another-client"}
+
+	noSubject := testClaims("nonce-1")
+	noSubject.Subject = ""
+
+	tests := []struct {
+		name    string
+		claims  Claims
+		nonce   string
+		wantErr error
+	}{
+		{"valid", testClaims("nonce-1"), "nonce-1", nil},
+		{"expired", expired, "nonce-1", ErrInvalidToken},
+		{"bad issuer", badIssuer, "nonce-1", ErrInvalidToken},
+		{"bad audience", badAudience, "nonce-1", ErrInvalidToken},
+		{"no subject", noSubject, "nonce-1", ErrInvalidToken},
+		{"nonce mismatch", testClaims("nonce-1"), "nonce-2", ErrNonceMismatch},
+		{"nonce missing from token", testClaims(""), "nonce-1", ErrNonceMismatch},
+		{"nonce missing from request", testClaims("nonce-1"), "", ErrNonceMismatch},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := verifier.Verify(signed(t, fake, test.claims), test.nonce)
+
+			if test.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				if claims.Subject != "subject-1" || claims.Email != "tenant@example.com" || !bool(claims.EmailVerified) {
+					t.Errorf("unexpected claims %+v", claims)
+				}
+				return
+			}
+
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Verify error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyHashedNonce(t *testing.T) {
+	fake := startFake(t)
+	config := fake.Config("Apple", []string{testClientID})
+	config.HashedNonce = true
+	verifier := NewVerifier(config)
+
+	hash := sha256.Sum256([]byte("raw-nonce"))
+	hashed := hex.EncodeToString(hash[:])
+
+	if _, err := verifier.Verify(signed(t, fake, testClaims(hashed)), "raw-nonce"); err != nil {
+		t.Fatalf("Verify with the raw nonce of a hashed token: %v", err)
+	}
+
+	if _, err := verifier.Verify(signed(t, fake, testClaims(hashed)), hashed); !errors.Is(err, ErrNonceMismatch) {
+		t.Fatalf("Verify with the hash itself: error = %v, want %v", err, ErrNonceMismatch)
+	}
+
+	if _, err := verifier.Verify(signed(t, fake, testClaims("raw-nonce")), "raw-nonce"); !errors.Is(err, ErrNonceMismatch) {
+		t.Fatalf("Verify of an unhashed token: error = %v, want %v", err, ErrNonceMismatch)
+	}
+}
+
+func TestVerifyRejectsUnknownKeys(t *testing.T) {
+	fake := startFake(t)
+	verifier := NewVerifier(fake.Config("Google", []string{testClientID}))
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := testClaims("nonce-1")
+	claims.Issuer = fake.Issuer
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name string
+		kid  string
+		key  *rsa.PrivateKey
+	}{
+		{"unknown kid", "unknown-key", fake.key},
+		{"wrong key for kid", fakeKeyID, otherKey},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+			token.Header["kid"] = test.kid
+
+			rawToken, err := token.SignedString(test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := verifier.Verify(rawToken, "nonce-1"); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("Verify error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestVerifyWithoutClientIDs(t *testing.T) {
+	fake := startFake(t)
+	verifier := NewVerifier(fake.Config("Google", nil))
+
+	if _, err := verifier.Verify(signed(t, fake, testClaims("nonce-1")), "nonce-1"); !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("Verify error = %v, want %v", err, ErrNotConfigured)
+	}
+}
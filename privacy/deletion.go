@@ -0,0 +1,224 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"habitat-server/billing"
+	"habitat-server/models"
+	"habitat-server/storage"
+	"habitat-server/utils"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"gorm.io/gorm"
+)
+
+const defaultDeletionGraceDays = 14
+
+// DeletionGracePeriod is how long a requested deletion waits before it is
+// carried out, during which the user can cancel it. It is set in days with
+// ACCOUNT_DELETION_GRACE_DAYS.
+func DeletionGracePeriod() time.Duration {
+	days := defaultDeletionGraceDays
+	if configured, err := strconv.Atoi(os.Getenv("ACCOUNT_DELETION_GRACE_DAYS")); err == nil && configured >= 0 {
+		days = configured
+	}
+
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ScheduleDeletion marks the account for deletion once the grace period has
+// passed and returns when that will be.
+func ScheduleDeletion(userID uint) (time.Time, error) {
+	scheduledFor := time.Now().Add(DeletionGracePeriod())
+	err := storage.DB.Model(&models.User{}).Where("id = ?", userID).Update("deletion_scheduled_for", scheduledFor).Error
+
+	return scheduledFor, err
+}
+
+func CancelDeletion(userID uint) error {
+	return storage.DB.Model(&models.User{}).Where("id = ?", userID).Update("deletion_scheduled_for", nil).Error
+}
+
+func deleteDueAccounts(ctx context.Context) error {
+	var userIDs []uint
+	err := storage.DB.Model(&models.User{}).
+		Where("deletion_scheduled_for <= ?", time.Now()).
+		Pluck("id", &userIDs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		if err := DeleteAccount(ctx, userID); err != nil {
+			log.Println("privacy: deleting user", userID, "failed:", err)
+			continue
+		}
+
+		log.Println("privacy: deleted user", userID)
+	}
+
+	return nil
+}
+
+// DeleteAccount erases a user. The user row is kept, stripped of anything
+// identifying, so the reviews, messages and reservations other people rely
+// on survive but are attributed to a deleted user. Listings, linked logins,
+// exports, uploaded images and the files the user attached to messages are
+// removed, any subscription is canceled and every session is signed out.
+func DeleteAccount(ctx context.Context, userID uint) error {
+	var user models.User
+	if err := storage.DB.First(&user, userID).Error; err != nil {
+		return err
+	}
+
+	var properties []models.Property
+	if err := storage.DB.Preload("Apartments").Where("user_id = ?", userID).Find(&properties).Error; err != nil {
+		return err
+	}
+
+	var attachments []models.MessageAttachment
+	err := storage.DB.
+		Where("message_id IN (?)", storage.DB.Model(&models.Message{}).Select("id").Where("sender_id = ?", userID)).
+		Find(&attachments).Error
+	if err != nil {
+		return err
+	}
+
+	var subscriptions []models.Subscription
+	err = storage.DB.Where("user_id = ? AND status IN ?", userID,
+		[]models.SubscriptionStatus{models.SubscriptionActive, models.SubscriptionPastDue}).
+		Find(&subscriptions).Error
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if err := billing.Default.CancelSubscription(ctx, subscription.ExternalID); err != nil {
+			return fmt.Errorf("canceling subscription %d: %w", subscription.ID, err)
+		}
+	}
+
+	var imageURLs []string
+	if user.AvatarURL != "" {
+		imageURLs = append(imageURLs, user.AvatarURL)
+	}
+
+	propertyIDs := make([]uint, 0, len(properties))
+	for _, property := range properties {
+		propertyIDs = append(propertyIDs, property.ID)
+		imageURLs = append(imageURLs, imageList(property.Images)...)
+		for _, apartment := range property.Apartments {
+			imageURLs = append(imageURLs, imageList(apartment.Images)...)
+		}
+	}
+
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		if len(propertyIDs) > 0 {
+			if err := tx.Where("property_id IN ?", propertyIDs).Delete(&models.Apartment{}).Error; err != nil {
+				return err
+			}
+
+			// Listings carry their own copy of the owner's contact details.
+			err := tx.Model(&models.Property{}).Where("id IN ?", propertyIDs).Updates(map[string]interface{}{
+				"email":        "",
+				"first_name":   "",
+				"last_name":    "",
+				"phone_number": "",
+				"images":       nil,
+				"on_market":    false,
+			}).Error
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Delete(&models.Property{}, propertyIDs).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(attachments) > 0 {
+			if err := tx.Unscoped().Delete(&attachments).Error; err != nil {
+				return err
+			}
+		}
+
+		err := tx.Model(&models.Promotion{}).
+			Where("user_id = ? AND canceled_at IS NULL", userID).
+			Update("canceled_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+
+		for _, record := range []interface{}{&models.Identity{}, &models.RecoveryCode{}, &models.DataExport{}} {
+			if err := tx.Unscoped().Where("user_id = ?", userID).Delete(record).Error; err != nil {
+				return err
+			}
+		}
+
+		err = tx.Model(&user).Updates(map[string]interface{}{
+			"first_name":             "Deleted",
+			"last_name":              "User",
+			"email":                  fmt.Sprintf("deleted-%d@users.invalid", userID),
+			"password":               "",
+			"social_login":           false,
+			"social_provider":        "",
+			"saved_properties":       nil,
+			"push_tokens":            nil,
+			"allows_notifications":   nil,
+			"avatar_url":             "",
+			"bio":                    "",
+			"phone":                  "",
+			"languages":              nil,
+			"profile_visibility":     nil,
+			"two_factor_enabled":     false,
+			"two_factor_secret":      "",
+			"deletion_scheduled_for": nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&user).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := utils.RevokeAllSessions(userID); err != nil {
+		return err
+	}
+
+	for _, imageURL := range imageURLs {
+		if err := storage.DeleteImage(ctx, imageURL); err != nil {
+			log.Println("privacy: image", imageURL, "not deleted:", err)
+		}
+	}
+
+	for _, attachment := range attachments {
+		if err := storage.DeletePrivateFile(ctx, attachment.PublicID, attachment.ResourceType); err != nil {
+			log.Println("privacy: attachment", attachment.ID, "not deleted:", err)
+		}
+
+		if attachment.HasThumbnail {
+			if err := storage.DeletePrivateFile(ctx, attachment.ThumbnailPublicID, api.Image.String()); err != nil {
+				log.Println("privacy: attachment", attachment.ID, "thumbnail not deleted:", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func imageList(images []byte) []string {
+	var urls []string
+	if len(images) > 0 {
+		json.Unmarshal(images, &urls)
+	}
+
+	return urls
+}
@@ -0,0 +1,198 @@
+package privacy
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"habitat-server/models"
+	"habitat-server/storage"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// exportLifetime is how long a finished archive can be downloaded.
+	exportLifetime = 7 * 24 * time.Hour
+	// exportTimeout is how long an export may stay pending before it is
+	// considered lost, e.g. to a restart, and marked failed.
+	exportTimeout = time.Hour
+)
+
+// StartExport queues an archive of the user's data and builds it in the
+// background. An export that is already pending is returned instead of
+// starting another.
+func StartExport(userID uint) (*models.DataExport, error) {
+	var export models.DataExport
+	pendingExists := storage.DB.
+		Where("user_id = ? AND status = ? AND created_at > ?", userID, models.DataExportPending, time.Now().Add(-exportTimeout)).
+		Limit(1).Find(&export)
+
+	if pendingExists.Error != nil {
+		return nil, pendingExists.Error
+	}
+
+	if pendingExists.RowsAffected > 0 {
+		return &export, nil
+	}
+
+	export = models.DataExport{UserID: userID, Status: models.DataExportPending}
+	if err := storage.DB.Create(&export).Error; err != nil {
+		return nil, err
+	}
+
+	go finishExport(export)
+
+	return &export, nil
+}
+
+func finishExport(export models.DataExport) {
+	archive, err := BuildArchive(export.UserID)
+	if err != nil {
+		log.Println("privacy: export", export.ID, "failed:", err)
+		storage.DB.Model(&export).Update("status", models.DataExportFailed)
+		return
+	}
+
+	expiresAt := time.Now().Add(exportLifetime)
+	err = storage.DB.Model(&export).Updates(map[string]interface{}{
+		"status":     models.DataExportReady,
+		"archive":    archive,
+		"size":       len(archive),
+		"expires_at": expiresAt,
+	}).Error
+	if err != nil {
+		log.Println("privacy: export", export.ID, "not saved:", err)
+	}
+}
+
+// exportProfile is the user's account without credentials and secrets.
+type exportProfile struct {
+	ID                   uint                  `json:"ID"`
+	CreatedAt            time.Time             `json:"createdAt"`
+	FirstName            string                `json:"firstName"`
+	LastName             string                `json:"lastName"`
+	Email                string                `json:"email"`
+	AvatarURL            string                `json:"avatarURL"`
+	Bio                  string                `json:"bio"`
+	Phone                string                `json:"phone"`
+	Languages            []string              `json:"languages"`
+	ProfileVisibility    map[string]bool       `json:"profileVisibility"`
+	IsVerified           *bool                 `json:"isVerified"`
+	Locale               string                `json:"locale"`
+	MembershipTier       models.MembershipTier `json:"membershipTier"`
+	Role                 models.Role           `json:"role"`
+	AllowsNotifications  *bool                 `json:"allowsNotifications"`
+	TwoFactorEnabled     bool                  `json:"twoFactorEnabled"`
+	DeletionScheduledFor *time.Time            `json:"deletionScheduledFor"`
+}
+
+// BuildArchive collects everything stored about the user into a ZIP of
+// JSON files, one per kind of record.
+func BuildArchive(userID uint) ([]byte, error) {
+	var user models.User
+	if err := storage.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	var savedPropertyIDs []uint
+	if len(user.SavedProperties) > 0 {
+		json.Unmarshal(user.SavedProperties, &savedPropertyIDs)
+	}
+
+	var identities []models.Identity
+	var properties []models.Property
+	var savedProperties []models.Property
+	var reviews []models.Review
+	var conversations []models.Conversation
+	var reservations []models.Reservation
+	var subscriptions []models.Subscription
+
+	queries := []struct {
+		db   *gorm.DB
+		dest interface{}
+	}{
+		{storage.DB.Where("user_id = ?", userID), &identities},
+		{storage.DB.Preload("Apartments").Where("user_id = ?", userID), &properties},
+		{storage.DB.Where("id IN ?", append(savedPropertyIDs, 0)), &savedProperties},
+		{storage.DB.Where("user_id = ?", userID), &reviews},
+		{storage.DB.Preload("Messages.Attachments").Where("tenant_id = ? OR owner_id = ?", userID, userID), &conversations},
+		{storage.DB.Where("user_id = ?", userID), &reservations},
+		{storage.DB.Where("user_id = ?", userID), &subscriptions},
+	}
+
+	for _, query := range queries {
+		if err := query.db.Find(query.dest).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", exportProfile{
+			ID:                   user.ID,
+			CreatedAt:            user.CreatedAt,
+			FirstName:            user.FirstName,
+			LastName:             user.LastName,
+			Email:                user.Email,
+			AvatarURL:            user.AvatarURL,
+			Bio:                  user.Bio,
+			Phone:                user.Phone,
+			Languages:            user.LanguageList(),
+			ProfileVisibility:    user.Visibility(),
+			IsVerified:           user.IsVerified,
+			Locale:               user.Locale,
+			MembershipTier:       user.MembershipTier,
+			Role:                 user.Role,
+			AllowsNotifications:  user.AllowsNotifications,
+			TwoFactorEnabled:     user.TwoFactorEnabled,
+			DeletionScheduledFor: user.DeletionScheduledFor,
+		}},
+		{"linked_logins.json", identities},
+		{"properties.json", properties},
+		{"saved_properties.json", savedProperties},
+		{"reviews.json", reviews},
+		{"conversations.json", conversations},
+		{"reservations.json", reservations},
+		{"subscriptions.json", subscriptions},
+	}
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for _, file := range files {
+		writer, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// cleanUpExports deletes archives past their expiry and fails exports that
+// never finished.
+func cleanUpExports() error {
+	err := storage.DB.Unscoped().
+		Where("expires_at < ?", time.Now()).
+		Delete(&models.DataExport{}).Error
+	if err != nil {
+		return err
+	}
+
+	return storage.DB.Model(&models.DataExport{}).
+		Where("status = ? AND created_at < ?", models.DataExportPending, time.Now().Add(-exportTimeout)).
+		Update("status", models.DataExportFailed).Error
+}
@@ -0,0 +1,43 @@
+package privacy
+
+import (
+	"context"
+	"habitat-server/storage"
+	"log"
+	"time"
+)
+
+// sweepInterval is how often due account deletions and expired exports are
+// processed.
+const sweepInterval = time.Hour
+
+// Initialize starts the background sweep. Every instance runs it; a Redis
+// lock makes sure only one does the work each interval.
+func Initialize() {
+	go func() {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+
+		for {
+			sweep()
+			<-ticker.C
+		}
+	}()
+}
+
+func sweep() {
+	ctx := context.Background()
+
+	acquired, err := storage.Redis.SetNX(ctx, "privacy:sweep", "true", sweepInterval-time.Minute).Result()
+	if err != nil || !acquired {
+		return
+	}
+
+	if err := deleteDueAccounts(ctx); err != nil {
+		log.Println("privacy: account deletions:", err)
+	}
+
+	if err := cleanUpExports(); err != nil {
+		log.Println("privacy: export clean up:", err)
+	}
+}
@@ -0,0 +1,124 @@
+package ratings
+
+import (
+	"habitat-server/models"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultPriorWeight is how many reviews' worth of the site-wide mean a
+	// property's score starts with, so a single 5-star review does not
+	// outrank dozens of 4.8s.
+	defaultPriorWeight = 5
+	// priorMeanTTL is how long the site-wide mean is cached between queries.
+	priorMeanTTL = 15 * time.Minute
+)
+
+// Contribution is what a review adds to its property's review count and
+// star sum: only published reviews that have not been deleted count.
+func Contribution(review models.Review) (count int64, stars int64) {
+	if review.Status != models.ReviewPublished || review.DeletedAt.Valid {
+		return 0, 0
+	}
+
+	return 1, int64(review.Stars)
+}
+
+// Track moves the property's aggregates from what before contributed to what
+// after contributes. Pass a zero Review for a review being created or
+// removed. It must run in the transaction that changes the review.
+func Track(tx *gorm.DB, propertyID uint, before models.Review, after models.Review) error {
+	beforeCount, beforeStars := Contribution(before)
+	afterCount, afterStars := Contribution(after)
+
+	return Adjust(tx, propertyID, afterCount-beforeCount, afterStars-beforeStars)
+}
+
+// Adjust adds to a property's review count and star sum in one statement,
+// so concurrent reviews can't overwrite each other's changes, and refreshes
+// the average shown on listings.
+func Adjust(tx *gorm.DB, propertyID uint, count int64, stars int64) error {
+	if count == 0 && stars == 0 {
+		return nil
+	}
+
+	return tx.Model(&models.Property{}).Where("id = ?", propertyID).Updates(map[string]interface{}{
+		"review_count": gorm.Expr("review_count + ?", count),
+		"star_sum":     gorm.Expr("star_sum + ?", stars),
+		"stars": gorm.Expr("CASE WHEN review_count + ? > 0 THEN ROUND((star_sum + ?)::numeric / (review_count + ?), 1) ELSE 0 END",
+			count, stars, count),
+	}).Error
+}
+
+// RecomputeAll rebuilds every property's aggregates from its reviews and
+// returns how many had drifted.
+func RecomputeAll(tx *gorm.DB) (int64, error) {
+	result := tx.Exec(`UPDATE properties SET
+			review_count = totals.review_count,
+			star_sum = totals.star_sum,
+			stars = CASE WHEN totals.review_count > 0 THEN ROUND(totals.star_sum::numeric / totals.review_count, 1) ELSE 0 END
+		FROM (
+			SELECT properties.id, COUNT(reviews.id) AS review_count, COALESCE(SUM(reviews.stars), 0) AS star_sum
+			FROM properties
+			LEFT JOIN reviews ON reviews.property_id = properties.id
+				AND reviews.status = ? AND reviews.deleted_at IS NULL
+			GROUP BY properties.id
+		) totals
+		WHERE properties.id = totals.id
+			AND (properties.review_count IS DISTINCT FROM totals.review_count
+				OR properties.star_sum IS DISTINCT FROM totals.star_sum)`,
+		models.ReviewPublished)
+
+	return result.RowsAffected, result.Error
+}
+
+// Score is the Bayesian average of a property's reviews: its star sum
+// blended with priorWeight reviews at the site-wide mean. Properties with
+// few reviews sit near the mean until they earn more.
+func Score(count int64, stars int64, mean float64) float64 {
+	weight := PriorWeight()
+	return (weight*mean + float64(stars)) / (weight + float64(count))
+}
+
+// PriorWeight can be tuned with RATING_PRIOR_WEIGHT.
+func PriorWeight() float64 {
+	if weight, err := strconv.ParseFloat(os.Getenv("RATING_PRIOR_WEIGHT"), 64); err == nil && weight > 0 {
+		return weight
+	}
+
+	return defaultPriorWeight
+}
+
+var priorMean struct {
+	sync.Mutex
+	value     float64
+	fetchedAt time.Time
+}
+
+// PriorMean is the average star rating across all published reviews.
+func PriorMean(db *gorm.DB) (float64, error) {
+	priorMean.Lock()
+	defer priorMean.Unlock()
+
+	if time.Since(priorMean.fetchedAt) < priorMeanTTL {
+		return priorMean.value, nil
+	}
+
+	var mean float64
+	err := db.Model(&models.Property{}).
+		Select("COALESCE(SUM(star_sum)::numeric / NULLIF(SUM(review_count), 0), 0)").
+		Scan(&mean).Error
+	if err != nil {
+		return 0, err
+	}
+
+	priorMean.value = mean
+	priorMean.fetchedAt = time.Now()
+
+	return mean, nil
+}
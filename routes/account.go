@@ -0,0 +1,151 @@
+package routes
+
+import (
+	"habitat-server/models"
+	"habitat-server/privacy"
+	"habitat-server/storage"
+	"habitat-server/utils"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// RequestDataExport starts building an archive of the user's data. The
+// client polls GetDataExports until it is ready to download.
+func RequestDataExport(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	export, err := privacy.StartExport(claims.ID)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusAccepted)
+	ctx.JSON(export)
+}
+
+func GetDataExports(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var exports []models.DataExport
+	exportsQuery := storage.DB.Omit("archive").
+		Where("user_id = ?", claims.ID).
+		Order("created_at DESC").
+		Find(&exports)
+
+	if exportsQuery.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(exports)
+}
+
+func DownloadDataExport(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	params := ctx.Params()
+	id := params.Get("id")
+
+	var export models.DataExport
+	exportExists := storage.DB.Where("id = ? AND user_id = ?", id, claims.ID).Limit(1).Find(&export)
+
+	if exportExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if exportExists.RowsAffected == 0 || export.Status != models.DataExportReady ||
+		export.ExpiresAt == nil || export.ExpiresAt.Before(time.Now()) {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	filename := "habitat-export-" + export.CreatedAt.Format("2006-01-02") + ".zip"
+	ctx.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	ctx.Header("Content-Length", strconv.Itoa(len(export.Archive)))
+	ctx.ContentType("application/zip")
+	ctx.Write(export.Archive)
+}
+
+// RequestAccountDeletion schedules the account for deletion after the grace
+// period. The user confirms with their password and, with two-factor on, a
+// code. Accounts without a password confirm with their second factor or, if
+// they have none, a code emailed to them: a request without emailCode sends
+// one and is answered 202 Accepted.
+func RequestAccountDeletion(ctx iris.Context) {
+	user := getCurrentUser(ctx)
+	if user == nil {
+		return
+	}
+
+	var req AccountDeletionInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	if user.Password != "" && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+		utils.CreateError(iris.StatusUnauthorized, "Credentials Error", "Incorrect password.", ctx)
+		return
+	}
+
+	if user.TwoFactorEnabled && !checkSecondFactor(user, SecondFactorInput{Code: req.Code, RecoveryCode: req.RecoveryCode}, ctx) {
+		return
+	}
+
+	if user.Password == "" && !user.TwoFactorEnabled {
+		if req.EmailCode == "" {
+			go sendLoginCodeEmail(*user)
+			ctx.StatusCode(iris.StatusAccepted)
+			ctx.JSON(iris.Map{
+				"emailSent": true,
+			})
+			return
+		}
+
+		matched, err := utils.CheckLoginCode(user.Email, req.EmailCode, "")
+		if err != nil {
+			utils.CreateInternalServerError(ctx)
+			return
+		}
+
+		if !matched {
+			utils.CreateError(iris.StatusUnauthorized, "Credentials Error", "Invalid or expired code.", ctx)
+			return
+		}
+	}
+
+	scheduledFor, err := privacy.ScheduleDeletion(user.ID)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"deletionScheduledFor": scheduledFor,
+	})
+}
+
+func CancelAccountDeletion(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	if err := privacy.CancelDeletion(claims.ID); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+type AccountDeletionInput struct {
+	Password     string `json:"password" validate:"max=256"`
+	Code         string `json:"code" validate:"max=16"`
+	RecoveryCode string `json:"recoveryCode" validate:"max=32"`
+	EmailCode    string `json:"emailCode" validate:"max=16"`
+}
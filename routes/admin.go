@@ -0,0 +1,524 @@
+package routes
+
+import (
+	"habitat-server/models"
+	"habitat-server/ratings"
+	"habitat-server/storage"
+	"habitat-server/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func AdminSearchUsers(ctx iris.Context) {
+	query := strings.TrimSpace(ctx.URLParam("q"))
+	limit := ctx.URLParamIntDefault("limit", 25)
+	offset := ctx.URLParamIntDefault("offset", 0)
+	if limit < 1 || limit > 100 {
+		limit = 25
+	}
+
+	usersQuery := storage.DB.Model(&models.User{}).Order("id ASC").Limit(limit).Offset(offset)
+	if query != "" {
+		like := "%" + strings.ToLower(query) + "%"
+		usersQuery = usersQuery.Where(
+			"lower(email) LIKE ? OR lower(first_name) LIKE ? OR lower(last_name) LIKE ?",
+			like, like, like)
+	}
+
+	var users []models.User
+	if err := usersQuery.Find(&users).Error; err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if err := utils.RecordAudit(storage.DB, ctx, "user.search", "user", 0, iris.Map{"query": query}); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	results := make([]AdminUser, 0, len(users))
+	for _, user := range users {
+		results = append(results, newAdminUser(user))
+	}
+
+	ctx.JSON(results)
+}
+
+func AdminGetUser(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	user := getUserByID(id, ctx)
+	if user == nil {
+		return
+	}
+
+	if err := utils.RecordAudit(storage.DB, ctx, "user.view", "user", user.ID, nil); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(newAdminUser(*user))
+}
+
+func AdminGetUserProperties(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	var properties []models.Property
+	propertiesExist := storage.DB.Unscoped().Preload(clause.Associations).Where("user_id = ?", id).Find(&properties)
+
+	if propertiesExist.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if err := auditUserRead(ctx, "user.view_properties", id); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(properties)
+}
+
+func AdminGetUserConversations(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	var conversations []models.Conversation
+	conversationsExist := storage.DB.Preload("Messages.Attachments").
+		Where("tenant_id = ? OR owner_id = ?", id, id).
+		Order("updated_at DESC").
+		Find(&conversations)
+
+	if conversationsExist.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if err := auditUserRead(ctx, "user.view_conversations", id); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(conversations)
+}
+
+func AdminGetUserReservations(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	var reservations []models.Reservation
+	reservationsExist := storage.DB.Preload("Property").Where("user_id = ?", id).Find(&reservations)
+
+	if reservationsExist.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if err := auditUserRead(ctx, "user.view_reservations", id); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(reservations)
+}
+
+func AdminSuspendUser(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	user := getUserByID(id, ctx)
+	if user == nil {
+		return
+	}
+
+	var req AdminReasonInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	now := time.Now()
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		userUpdate := tx.Model(user).Updates(map[string]interface{}{
+			"suspended_at":      now,
+			"suspension_reason": req.Reason,
+		})
+		if userUpdate.Error != nil {
+			return userUpdate.Error
+		}
+
+		return utils.RecordAudit(tx, ctx, "user.suspend", "user", user.ID, iris.Map{"reason": req.Reason})
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if err := utils.RevokeAllSessions(user.ID); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+func AdminReactivateUser(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	user := getUserByID(id, ctx)
+	if user == nil {
+		return
+	}
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		userUpdate := tx.Model(user).Updates(map[string]interface{}{
+			"suspended_at":      nil,
+			"suspension_reason": "",
+		})
+		if userUpdate.Error != nil {
+			return userUpdate.Error
+		}
+
+		return utils.RecordAudit(tx, ctx, "user.reactivate", "user", user.ID, nil)
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+func AdminForceLogout(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	user := getUserByID(id, ctx)
+	if user == nil {
+		return
+	}
+
+	if err := utils.RevokeAllSessions(user.ID); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if err := utils.RecordAudit(storage.DB, ctx, "user.force_logout", "user", user.ID, nil); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+func AdminUpdateMembershipTier(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	user := getUserByID(id, ctx)
+	if user == nil {
+		return
+	}
+
+	var req UpdateMembershipTierInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	previousTier := user.MembershipTier
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		pausedListings, err := applyTierChange(tx, user.ID, req.MembershipTier)
+		if err != nil {
+			return err
+		}
+
+		return utils.RecordAudit(tx, ctx, "user.membership_tier", "user", user.ID, iris.Map{
+			"from":           previousTier,
+			"to":             req.MembershipTier,
+			"pausedListings": pausedListings,
+		})
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// AdminSetTwoFactorRequired makes two-factor mandatory for a user. They are
+// signed out, and until they enroll signing in only lets them enroll.
+func AdminSetTwoFactorRequired(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	user := getUserByID(id, ctx)
+	if user == nil {
+		return
+	}
+
+	var req AdminTwoFactorRequiredInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("two_factor_required", *req.Required).Error; err != nil {
+			return err
+		}
+
+		return utils.RecordAudit(tx, ctx, "user.two_factor_required", "user", user.ID, iris.Map{
+			"required": *req.Required,
+		})
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	// Tokens carry the requirement, so make the user sign in again.
+	if err := utils.RevokeAllSessions(user.ID); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// AdminResetTwoFactor removes a user's authenticator and recovery codes
+// after support has verified their identity, so they can enroll again.
+func AdminResetTwoFactor(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	user := getUserByID(id, ctx)
+	if user == nil {
+		return
+	}
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := clearTwoFactor(tx, user.ID); err != nil {
+			return err
+		}
+
+		return utils.RecordAudit(tx, ctx, "user.two_factor_reset", "user", user.ID, nil)
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if err := utils.RevokeAllSessions(user.ID); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+func AdminTakeDownProperty(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	var property models.Property
+	propertyExists := storage.DB.Find(&property, id)
+
+	if propertyExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if propertyExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	var req AdminReasonInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		propertyUpdate := tx.Model(&property).Updates(map[string]interface{}{
+			"status":           models.ListingTakenDown,
+			"rejection_reason": req.Reason,
+			"on_market":        false,
+		})
+		if propertyUpdate.Error != nil {
+			return propertyUpdate.Error
+		}
+
+		return utils.RecordAudit(tx, ctx, "property.take_down", "property", property.ID, iris.Map{
+			"reason":  req.Reason,
+			"ownerID": property.UserID,
+		})
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+func AdminDeleteReview(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	var review models.Review
+	reviewExists := storage.DB.Find(&review, id)
+
+	if reviewExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if reviewExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	var req AdminReasonInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		before, err := lockReview(tx, review.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&review).Error; err != nil {
+			return err
+		}
+
+		if err := ratings.Track(tx, review.PropertyID, before, models.Review{}); err != nil {
+			return err
+		}
+
+		return utils.RecordAudit(tx, ctx, "review.delete", "review", review.ID, iris.Map{
+			"reason":     req.Reason,
+			"propertyID": review.PropertyID,
+			"authorID":   review.UserID,
+			"title":      review.Title,
+			"body":       review.Body,
+			"stars":      review.Stars,
+		})
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+func AdminGetAuditLog(ctx iris.Context) {
+	limit := ctx.URLParamIntDefault("limit", 50)
+	offset := ctx.URLParamIntDefault("offset", 0)
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	logQuery := storage.DB.Order("id DESC").Limit(limit).Offset(offset)
+	if actorID := ctx.URLParam("actorID"); actorID != "" {
+		logQuery = logQuery.Where("actor_id = ?", actorID)
+	}
+	if targetType := ctx.URLParam("targetType"); targetType != "" {
+		logQuery = logQuery.Where("target_type = ?", targetType)
+	}
+	if targetID := ctx.URLParam("targetID"); targetID != "" {
+		logQuery = logQuery.Where("target_id = ?", targetID)
+	}
+
+	var entries []models.AuditLog
+	if err := logQuery.Find(&entries).Error; err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(entries)
+}
+
+// auditUserRead records an admin looking at a user's records.
+func auditUserRead(ctx iris.Context, action string, id string) error {
+	userID, err := strconv.ParseUint(id, 10, 0)
+	if err != nil {
+		return err
+	}
+
+	return utils.RecordAudit(storage.DB, ctx, action, "user", uint(userID), nil)
+}
+
+// AdminUser is a user as shown to admins: the account and its standing,
+// without credentials or personal details admins don't need.
+type AdminUser struct {
+	ID                   uint                  `json:"ID"`
+	CreatedAt            time.Time             `json:"createdAt"`
+	FirstName            string                `json:"firstName"`
+	LastName             string                `json:"lastName"`
+	Email                string                `json:"email"`
+	SocialLogin          bool                  `json:"socialLogin"`
+	SocialProvider       string                `json:"socialProvider"`
+	IsVerified           *bool                 `json:"isVerified"`
+	MembershipTier       models.MembershipTier `json:"membershipTier"`
+	Role                 models.Role           `json:"role"`
+	SuspendedAt          *time.Time            `json:"suspendedAt"`
+	SuspensionReason     string                `json:"suspensionReason"`
+	TwoFactorEnabled     bool                  `json:"twoFactorEnabled"`
+	TwoFactorRequired    bool                  `json:"twoFactorRequired"`
+	DeletionScheduledFor *time.Time            `json:"deletionScheduledFor"`
+}
+
+func newAdminUser(user models.User) AdminUser {
+	return AdminUser{
+		ID:                   user.ID,
+		CreatedAt:            user.CreatedAt,
+		FirstName:            user.FirstName,
+		LastName:             user.LastName,
+		Email:                user.Email,
+		SocialLogin:          user.SocialLogin,
+		SocialProvider:       user.SocialProvider,
+		IsVerified:           user.IsVerified,
+		MembershipTier:       user.MembershipTier,
+		Role:                 user.Role,
+		SuspendedAt:          user.SuspendedAt,
+		SuspensionReason:     user.SuspensionReason,
+		TwoFactorEnabled:     user.TwoFactorEnabled,
+		TwoFactorRequired:    user.TwoFactorRequired,
+		DeletionScheduledFor: user.DeletionScheduledFor,
+	}
+}
+
+type AdminReasonInput struct {
+	Reason string `json:"reason" validate:"required,max=2000"`
+}
+
+type AdminTwoFactorRequiredInput struct {
+	Required *bool `json:"required" validate:"required"`
+}
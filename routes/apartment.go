@@ -38,8 +38,7 @@ func UpdateApartments(ctx iris.Context) {
 
 	claims := jwt.Get(ctx).(*utils.AccessToken)
 
-	if property.UserID != claims.ID {
-		ctx.StatusCode(iris.StatusForbidden)
+	if !utils.Authorize(utils.CanManageProperty(claims, property), ctx) {
 		return
 	}
 
@@ -50,6 +49,10 @@ func UpdateApartments(ctx iris.Context) {
 		return
 	}
 
+	if !ownsApartments(property, updatedApartmentIDs(updatedApartments), ctx) {
+		return
+	}
+
 	var newApartments []models.Apartment
 	bedroomLow := property.BedroomLow
 	bedroomHigh := property.BedroomHigh
@@ -81,8 +84,14 @@ func UpdateApartments(ctx iris.Context) {
 		}
 
 		if apartment.ID != nil {
-			currApartment.ID = *apartment.ID
-			storage.DB.Model(&currApartment).Updates(currApartment)
+			apartmentUpdate := storage.DB.Model(&models.Apartment{}).
+				Where("id = ? AND property_id = ?", *apartment.ID, property.ID).
+				Updates(currApartment)
+
+			if apartmentUpdate.Error != nil {
+				utils.CreateInternalServerError(ctx)
+				return
+			}
 		} else {
 			newApartments = append(newApartments, currApartment)
 		}
@@ -102,6 +111,36 @@ func UpdateApartments(ctx iris.Context) {
 	ctx.StatusCode(iris.StatusNoContent)
 }
 
+// ownsApartments checks every apartment ID in an update belongs to the
+// property, answering 422 when one doesn't, so an owner can't edit another
+// listing's units by naming them.
+func ownsApartments(property *models.Property, ids []uint, ctx iris.Context) bool {
+	owned := make(map[uint]bool, len(property.Apartments))
+	for _, apartment := range property.Apartments {
+		owned[apartment.ID] = true
+	}
+
+	for _, id := range ids {
+		if !owned[id] {
+			utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Apartment", "An apartment in the update doesn't belong to this property.", ctx)
+			return false
+		}
+	}
+
+	return true
+}
+
+func updatedApartmentIDs(apartments []UpdateUnitsInput) []uint {
+	var ids []uint
+	for _, apartment := range apartments {
+		if apartment.ID != nil {
+			ids = append(ids, *apartment.ID)
+		}
+	}
+
+	return ids
+}
+
 type UpdateUnitsInput struct {
 	ID          *uint     `json:"ID"`
 	Unit        string    `json:"unit" validate:"max=512"`
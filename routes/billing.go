@@ -0,0 +1,307 @@
+package routes
+
+import (
+	"errors"
+	"habitat-server/billing"
+	"habitat-server/models"
+	"habitat-server/storage"
+	"habitat-server/utils"
+	"log"
+	"os"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
+)
+
+// webhookEventTTL is how long processed webhook IDs are remembered so
+// provider retries are not applied twice.
+const webhookEventTTL = 7 * 24 * time.Hour
+
+func GetSubscription(ctx iris.Context) {
+	user := getCurrentUser(ctx)
+	if user == nil {
+		return
+	}
+
+	activeListings, err := utils.CountActiveListings(user.ID)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	var subscription *models.Subscription
+	var current models.Subscription
+	subscriptionExists := storage.DB.Where("user_id = ?", user.ID).Order("created_at DESC").Limit(1).Find(&current)
+
+	if subscriptionExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if subscriptionExists.RowsAffected > 0 {
+		subscription = &current
+	}
+
+	ctx.JSON(iris.Map{
+		"membershipTier": user.MembershipTier,
+		"entitlements":   user.MembershipTier.Entitlements(),
+		"activeListings": activeListings,
+		"subscription":   subscription,
+	})
+}
+
+func CreateCheckout(ctx iris.Context) {
+	var req CheckoutInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	user := getCurrentUser(ctx)
+	if user == nil {
+		return
+	}
+
+	var activeCount int64
+	err = storage.DB.Model(&models.Subscription{}).
+		Where("user_id = ? AND status IN ?", user.ID,
+			[]models.SubscriptionStatus{models.SubscriptionActive, models.SubscriptionPastDue}).
+		Count(&activeCount).Error
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if activeCount > 0 {
+		utils.CreateError(iris.StatusConflict, "Already Subscribed", "Cancel the current subscription before changing tiers.", ctx)
+		return
+	}
+
+	checkout, err := billing.Default.CreateCheckout(ctx.Request().Context(), billing.CheckoutRequest{
+		UserID:     user.ID,
+		Email:      user.Email,
+		Tier:       req.MembershipTier,
+		SuccessURL: os.Getenv("BILLING_SUCCESS_URL"),
+		CancelURL:  os.Getenv("BILLING_CANCEL_URL"),
+	})
+
+	if errors.Is(err, billing.ErrTierNotForSale) {
+		utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Tier", "This membership tier cannot be purchased.", ctx)
+		return
+	}
+
+	if err != nil {
+		log.Println("billing checkout:", err)
+		utils.CreateError(iris.StatusBadGateway, "Billing Unavailable", "The billing provider could not be reached.", ctx)
+		return
+	}
+
+	ctx.JSON(checkout)
+}
+
+func CancelSubscription(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var subscription models.Subscription
+	subscriptionExists := storage.DB.
+		Where("user_id = ? AND status IN ?", claims.ID,
+			[]models.SubscriptionStatus{models.SubscriptionActive, models.SubscriptionPastDue}).
+		Order("created_at DESC").Limit(1).Find(&subscription)
+
+	if subscriptionExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if subscriptionExists.RowsAffected == 0 {
+		utils.CreateError(iris.StatusNotFound, "Not Found", "No active subscription.", ctx)
+		return
+	}
+
+	err := billing.Default.CancelSubscription(ctx.Request().Context(), subscription.ExternalID)
+	if err != nil {
+		log.Println("billing cancel:", err)
+		utils.CreateError(iris.StatusBadGateway, "Billing Unavailable", "The billing provider could not be reached.", ctx)
+		return
+	}
+
+	// The tier stays until the paid period ends; the provider's webhook
+	// performs the downgrade.
+	storage.DB.Model(&subscription).Update("cancel_at_period_end", true)
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+func BillingWebhook(ctx iris.Context) {
+	body, err := ctx.GetBody()
+	if err != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Could not read the request body.", ctx)
+		return
+	}
+
+	event, err := billing.Default.ParseWebhook(ctx.Request().Header, body)
+	if errors.Is(err, billing.ErrIgnoredEvent) {
+		ctx.StatusCode(iris.StatusOK)
+		return
+	}
+
+	if err != nil {
+		utils.CreateError(iris.StatusBadRequest, "Invalid Webhook", err.Error(), ctx)
+		return
+	}
+
+	eventKey := "billing:event:" + event.ID
+	firstDelivery, err := storage.Redis.SetNX(ctx.Request().Context(), eventKey, "true", webhookEventTTL).Result()
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if !firstDelivery {
+		ctx.StatusCode(iris.StatusOK)
+		return
+	}
+
+	if err := applyBillingEvent(event); err != nil {
+		// Forget the event so the provider's retry is processed.
+		storage.Redis.Del(ctx.Request().Context(), eventKey)
+		log.Println("billing webhook:", err)
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusOK)
+}
+
+func applyBillingEvent(event *billing.Event) error {
+	return storage.DB.Transaction(func(tx *gorm.DB) error {
+		var subscription models.Subscription
+		subscriptionExists := tx.Where("provider = ? AND external_id = ?", billing.Default.Name(), event.SubscriptionID).
+			Limit(1).Find(&subscription)
+
+		if subscriptionExists.Error != nil {
+			return subscriptionExists.Error
+		}
+
+		if subscriptionExists.RowsAffected == 0 {
+			if event.UserID == 0 || !event.Tier.IsValid() {
+				// Nothing to attach the event to, e.g. a failed payment for
+				// a subscription we never saw created.
+				return nil
+			}
+
+			subscription = models.Subscription{
+				UserID:     event.UserID,
+				Provider:   billing.Default.Name(),
+				ExternalID: event.SubscriptionID,
+				Tier:       event.Tier,
+			}
+		}
+
+		if event.Tier.IsValid() {
+			subscription.Tier = event.Tier
+		}
+		if event.CustomerID != "" {
+			subscription.CustomerID = event.CustomerID
+		}
+		if event.Status != "" {
+			subscription.Status = event.Status
+		}
+		if event.Type == billing.EventSubscriptionCanceled {
+			subscription.Status = models.SubscriptionCanceled
+		}
+		if event.CurrentPeriodEnd != nil {
+			subscription.CurrentPeriodEnd = event.CurrentPeriodEnd
+		}
+		if event.Type != billing.EventPaymentFailed {
+			subscription.CancelAtPeriodEnd = event.CancelAtPeriodEnd
+		}
+
+		if err := tx.Save(&subscription).Error; err != nil {
+			return err
+		}
+
+		switch subscription.Status {
+		case models.SubscriptionActive, models.SubscriptionPastDue:
+			// Past due keeps the tier while the provider retries payment.
+			_, err := applyTierChange(tx, subscription.UserID, subscription.Tier)
+			return err
+		case models.SubscriptionCanceled:
+			_, err := applyTierChange(tx, subscription.UserID, models.FreeTier)
+			return err
+		}
+
+		return nil
+	})
+}
+
+// applyTierChange moves a user to tier. On a downgrade, listings over the
+// new tier's allowance are taken off the market rather than deleted, keeping
+// the most recently created ones, and promotions over its slots are
+// canceled. It returns how many listings were paused.
+func applyTierChange(tx *gorm.DB, userID uint, tier models.MembershipTier) (int64, error) {
+	err := tx.Model(&models.User{}).Where("id = ?", userID).Update("membership_tier", tier).Error
+	if err != nil {
+		return 0, err
+	}
+
+	entitlements := tier.Entitlements()
+	if err := cancelExcessPromotions(tx, userID, entitlements); err != nil {
+		return 0, err
+	}
+
+	limit := entitlements.MaxActiveListings
+	if limit == models.Unlimited {
+		return 0, nil
+	}
+
+	var excessIDs []uint
+	err = tx.Model(&models.Property{}).
+		Where("user_id = ? AND status IN ? AND (on_market IS NULL OR on_market = true)",
+			userID, []models.ListingStatus{models.ListingPendingReview, models.ListingApproved}).
+		Order("created_at DESC").
+		Offset(limit).
+		Pluck("id", &excessIDs).Error
+
+	if err != nil || len(excessIDs) == 0 {
+		return 0, err
+	}
+
+	result := tx.Model(&models.Property{}).Where("id IN ?", excessIDs).Update("on_market", false)
+	return result.RowsAffected, result.Error
+}
+
+// cancelExcessPromotions cancels the running and scheduled promotions a tier
+// no longer has slots for, keeping the most recently created ones. Tiers
+// without featured placement keep none.
+func cancelExcessPromotions(tx *gorm.DB, userID uint, entitlements models.Entitlements) error {
+	slots := entitlements.PromotionSlots
+	if !entitlements.FeaturedPlacement {
+		slots = 0
+	}
+	if slots == models.Unlimited {
+		return nil
+	}
+
+	now := time.Now()
+	var excessIDs []uint
+	err := tx.Model(&models.Promotion{}).
+		Where("user_id = ? AND canceled_at IS NULL AND ends_at > ?", userID, now).
+		Order("created_at DESC").
+		Offset(slots).
+		Pluck("id", &excessIDs).Error
+
+	if err != nil || len(excessIDs) == 0 {
+		return err
+	}
+
+	return tx.Model(&models.Promotion{}).Where("id IN ?", excessIDs).Update("canceled_at", now).Error
+}
+
+type CheckoutInput struct {
+	MembershipTier models.MembershipTier `json:"membershipTier" validate:"required,oneof=Premium Pro"`
+}
@@ -5,7 +5,6 @@ import (
 	"habitat-server/models"
 	"habitat-server/storage"
 	"habitat-server/utils"
-	"sort"
 
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/middleware/jwt"
@@ -63,6 +62,9 @@ func CreateConversation(ctx iris.Context) {
 	ctx.JSON(conversation)
 }
 
+// GetConversationByID returns the conversation header: the participants,
+// the property and read markers. Messages are paged through with
+// GetConversationMessages.
 func GetConversationByID(ctx iris.Context) {
 	params := ctx.Params()
 	id := params.Get("id")
@@ -75,73 +77,257 @@ func GetConversationByID(ctx iris.Context) {
 
 	claims := jwt.Get(ctx).(*utils.AccessToken)
 
-	if result.OwnerID != claims.ID && result.TenantID != claims.ID {
-		ctx.StatusCode(iris.StatusForbidden)
+	if !utils.Authorize(utils.CanAccessConversation(claims, result.TenantID, result.OwnerID), ctx) {
 		return
 	}
 
-	var messages []models.Message
-	messagesQuery := storage.DB.Where("conversation_id = ?", id).Order("created_at DESC").Find(&messages)
+	unreadQuery := unreadMessages(claims.ID).Where("messages.conversation_id = ?", result.ID).Count(&result.UnreadCount)
 
-	if messagesQuery.Error != nil {
+	if unreadQuery.Error != nil {
 		utils.CreateInternalServerError(ctx)
 		return
 	}
 
-	result.Messages = messages
-
 	ctx.JSON(result)
 }
 
+// GetConversationMessages pages through a conversation's messages, newest
+// first. Pass before to load older history or after to fetch messages newer
+// than the ones already shown; hasMore reports whether there are further
+// messages in that direction.
+func GetConversationMessages(ctx iris.Context) {
+	conversation := getAccessibleConversation(ctx)
+	if conversation == nil {
+		return
+	}
+
+	hasBefore, hasAfter := ctx.URLParamExists("before"), ctx.URLParamExists("after")
+	if hasBefore && hasAfter {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Pass either before or after, not both.", ctx)
+		return
+	}
+
+	before, beforeErr := ctx.URLParamInt64("before")
+	after, afterErr := ctx.URLParamInt64("after")
+	if (hasBefore && (beforeErr != nil || before < 0)) || (hasAfter && (afterErr != nil || after < 0)) {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "before and after must be message IDs.", ctx)
+		return
+	}
+
+	limit := utils.PageLimit(ctx, 50, 200)
+
+	messagesQuery := storage.DB.Preload("Attachments").Where("conversation_id = ?", conversation.ID).Limit(limit + 1)
+	switch {
+	case hasAfter:
+		messagesQuery = messagesQuery.Where("id > ?", after).Order("id ASC")
+	case hasBefore:
+		messagesQuery = messagesQuery.Where("id < ?", before).Order("id DESC")
+	default:
+		messagesQuery = messagesQuery.Order("id DESC")
+	}
+
+	var messages []models.Message
+	if err := messagesQuery.Find(&messages).Error; err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	// after is read oldest first; responses are always newest first.
+	if hasAfter {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	ctx.JSON(iris.Map{
+		"messages": messages,
+		"hasMore":  hasMore,
+	})
+}
+
+// GetConversationsByUserID pages through the user's conversations, most
+// recently active first, each with its latest message and unread count.
 func GetConversationsByUserID(ctx iris.Context) {
 	params := ctx.Params()
 	id := params.Get("id")
 
-	results, err := getConversationResultsByUserID(id, ctx)
+	cursor, err := utils.DecodeCursor(ctx.URLParam("cursor"))
+	if err != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Invalid cursor.", ctx)
+		return
+	}
+
+	limit := utils.PageLimit(ctx, 20, 100)
 
+	results, err := getConversationResultsByUserID(id, cursor, limit+1)
 	if err != nil {
+		utils.CreateInternalServerError(ctx)
 		return
 	}
 
+	var nextCursor *string
+	if len(results) > limit {
+		results = results[:limit]
+		last := results[limit-1]
+		next := utils.EncodeCursor(int64(last.LastMessageID), last.ID)
+		nextCursor = &next
+	}
+
 	var conversationIDs []uint
+	var lastMessageIDs []uint
 	for _, conversation := range results {
 		conversationIDs = append(conversationIDs, conversation.ID)
+		lastMessageIDs = append(lastMessageIDs, conversation.LastMessageID)
 	}
 
 	var messages []models.Message
+	messagesQuery := storage.DB.Preload("Attachments").Where("id IN ?", lastMessageIDs).Find(&messages)
 
-	messagesQuery := storage.DB.Raw(`
-		SELECT messages.* 
-		FROM messages
-		INNER JOIN (
-			SELECT conversation_id, MAX(created_at) AS created_at
-			FROM messages
-			WHERE conversation_id IN ? 
-			GROUP BY conversation_id
-		) AS recentMessages
-		ON messages.conversation_id = recentMessages.conversation_id 
-		AND messages.created_at = recentMessages.created_at`, conversationIDs).Scan(&messages)
+	if messagesQuery.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
 
 	messageMap := make(map[uint][]models.Message)
 	for _, message := range messages {
-		messageSlice := []models.Message{message}
-		messageMap[message.ConversationID] = messageSlice
+		messageMap[message.ConversationID] = []models.Message{message}
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var unreadCounts []struct {
+		ConversationID uint
+		Unread         int64
+	}
+	unreadQuery := unreadMessages(claims.ID).
+		Where("messages.conversation_id IN ?", conversationIDs).
+		Select("messages.conversation_id, COUNT(*) AS unread").
+		Group("messages.conversation_id").
+		Scan(&unreadCounts)
+
+	if unreadQuery.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	unreadMap := make(map[uint]int64, len(unreadCounts))
+	for _, count := range unreadCounts {
+		unreadMap[count.ConversationID] = count.Unread
 	}
 
 	for index, conversation := range results {
 		results[index].Messages = messageMap[conversation.ID]
+		results[index].UnreadCount = unreadMap[conversation.ID]
 	}
 
-	if messagesQuery.Error != nil {
+	ctx.JSON(iris.Map{
+		"conversations": results,
+		"nextCursor":    nextCursor,
+	})
+}
+
+// MarkConversationRead moves the caller's read marker up to the given
+// message, or to the latest one when none is given. The marker never moves
+// back, so a late request from another device can't unread newer messages.
+func MarkConversationRead(ctx iris.Context) {
+	var req MarkConversationReadInput
+	err := ctx.ReadJSON(&req)
+	if err != nil && !iris.IsErrEmptyJSON(err) {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	conversation := getAccessibleConversation(ctx)
+	if conversation == nil {
+		return
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	messageQuery := storage.DB.Model(&models.Message{}).Where("conversation_id = ?", conversation.ID)
+	if req.MessageID != 0 {
+		messageQuery = messageQuery.Where("id = ?", req.MessageID)
+	}
+
+	var lastReadID uint
+	if err := messageQuery.Select("COALESCE(MAX(id), 0)").Scan(&lastReadID).Error; err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if lastReadID == 0 && req.MessageID != 0 {
+		utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Message", "The message is not part of this conversation.", ctx)
+		return
+	}
+
+	column := conversation.LastReadColumn(claims.ID)
+	err = storage.DB.Model(conversation).
+		UpdateColumn(column, gorm.Expr("GREATEST("+column+", ?)", lastReadID)).Error
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// GetUnreadCount is the number of unread messages across all of the
+// caller's conversations, for the app badge.
+func GetUnreadCount(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var unread int64
+	if err := unreadMessages(claims.ID).Count(&unread).Error; err != nil {
 		utils.CreateInternalServerError(ctx)
 		return
 	}
 
-	sort.Slice(results, func(i int, j int) bool {
-		return results[i].Messages[0].CreatedAt.After(results[j].Messages[0].CreatedAt)
+	ctx.JSON(iris.Map{
+		"unread": unread,
 	})
+}
+
+// unreadMessages selects the messages sent to userID after their read
+// marker in each of their conversations.
+func unreadMessages(userID uint) *gorm.DB {
+	return storage.DB.Model(&models.Message{}).
+		Joins("INNER JOIN conversations ON conversations.id = messages.conversation_id AND conversations.deleted_at IS NULL").
+		Where("messages.sender_id <> ?", userID).
+		Where(`((conversations.tenant_id = ? AND messages.id > conversations.tenant_last_read_message_id)
+			OR (conversations.owner_id = ? AND messages.id > conversations.owner_last_read_message_id))`, userID, userID)
+}
+
+// getAccessibleConversation loads the conversation in the path if the
+// caller is one of its participants.
+func getAccessibleConversation(ctx iris.Context) *models.Conversation {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	var conversation models.Conversation
+	conversationExists := storage.DB.Find(&conversation, id)
+
+	if conversationExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return nil
+	}
+
+	if conversationExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	if !utils.Authorize(utils.CanAccessConversation(claims, conversation.TenantID, conversation.OwnerID), ctx) {
+		return nil
+	}
 
-	ctx.JSON(results)
+	return &conversation
 }
 
 func getConversationResult(id string, ctx iris.Context) (ConversationResult, error) {
@@ -150,10 +336,12 @@ func getConversationResult(id string, ctx iris.Context) (ConversationResult, err
 		Select(`conversations.*,
 		 properties.name, properties.street, properties.city, properties.state, 
 		 owners.first_name as owner_first_name, owners.last_name as owner_last_name, owners.email as owner_email,
-		 tenants.first_name as tenant_first_name, tenants.last_name as tenant_last_name, tenants.email as tenant_email`).
+		 tenants.first_name as tenant_first_name, tenants.last_name as tenant_last_name, tenants.email as tenant_email,
+		 COALESCE(latest.message_id, 0) as last_message_id`).
 		Joins("INNER JOIN properties on properties.id = conversations.property_id").
 		Joins("INNER JOIN users owners on conversations.owner_id = owners.id").
 		Joins("INNER JOIN users tenants on conversations.tenant_id = tenants.id").
+		Joins(latestMessageJoin).
 		Where("conversations.id = ?", id).
 		Scan(&result)
 
@@ -170,30 +358,38 @@ func getConversationResult(id string, ctx iris.Context) (ConversationResult, err
 	return result, nil
 }
 
-func getConversationResultsByUserID(id string, ctx iris.Context) ([]ConversationResult, error) {
-	var result []ConversationResult
+// latestMessageJoin adds the ID of each conversation's latest message as
+// latest.message_id.
+const latestMessageJoin = `LEFT JOIN LATERAL (
+	SELECT MAX(messages.id) AS message_id FROM messages
+	WHERE messages.conversation_id = conversations.id AND messages.deleted_at IS NULL
+) latest ON true`
+
+// getConversationResultsByUserID returns up to limit of the user's
+// conversations after cursor, ordered by their latest message.
+func getConversationResultsByUserID(id string, cursor *utils.Cursor, limit int) ([]ConversationResult, error) {
+	result := []ConversationResult{}
 	resultQuery := storage.DB.Table("conversations").
 		Select(`conversations.*,
 		 properties.name, properties.street, properties.city, properties.state, 
 		 owners.first_name as owner_first_name, owners.last_name as owner_last_name, owners.email as owner_email,
-		 tenants.first_name as tenant_first_name, tenants.last_name as tenant_last_name, tenants.email as tenant_email`).
+		 tenants.first_name as tenant_first_name, tenants.last_name as tenant_last_name, tenants.email as tenant_email,
+		 COALESCE(latest.message_id, 0) as last_message_id`).
 		Joins("INNER JOIN properties on properties.id = conversations.property_id").
 		Joins("INNER JOIN users owners on conversations.owner_id = owners.id").
 		Joins("INNER JOIN users tenants on conversations.tenant_id = tenants.id").
-		Where("conversations.tenant_id = ?", id).Or("conversations.owner_id = ?", id).
-		Scan(&result)
-
-	if resultQuery.Error != nil {
-		utils.CreateInternalServerError(ctx)
-		return result, resultQuery.Error
-	}
-
-	if resultQuery.RowsAffected == 0 {
-		utils.CreateNotFound(ctx)
-		return result, errors.New("Result not found")
+		Joins(latestMessageJoin).
+		Where("(conversations.tenant_id = ? OR conversations.owner_id = ?)", id, id).
+		Order("last_message_id DESC, conversations.id DESC").
+		Limit(limit)
+
+	if cursor != nil {
+		resultQuery = resultQuery.Where(
+			"(COALESCE(latest.message_id, 0) < ? OR (COALESCE(latest.message_id, 0) = ? AND conversations.id < ?))",
+			cursor.Key, cursor.Key, cursor.ID)
 	}
 
-	return result, nil
+	return result, resultQuery.Scan(&result).Error
 }
 
 type ConversationResult struct {
@@ -215,8 +411,13 @@ type ConversationResult struct {
 	TenantFirstName string `json:"tenantFirstName"`
 	TenantLastName  string `json:"tenantLastName"`
 	TenantEmail     string `json:"tenantEmail"`
+	// Read markers
+	TenantLastReadMessageID uint  `json:"tenantLastReadMessageID"`
+	OwnerLastReadMessageID  uint  `json:"ownerLastReadMessageID"`
+	UnreadCount             int64 `json:"unreadCount" gorm:"-"`
 	// Conversation / Message
-	Messages []models.Message `gorm:"foreignKey:ID" json:"messages"`
+	LastMessageID uint             `json:"lastMessageID"`
+	Messages      []models.Message `gorm:"foreignKey:ID" json:"messages,omitempty"` // latest message only, in lists
 }
 
 type CreateConversationInput struct {
@@ -227,3 +428,7 @@ type CreateConversationInput struct {
 	ReceiverID uint   `json:"receiverID" validate:"required"`
 	Text       string `json:"text" validate:"required,lt=5000"`
 }
+
+type MarkConversationReadInput struct {
+	MessageID uint `json:"messageID"` // defaults to the latest message
+}
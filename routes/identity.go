@@ -0,0 +1,394 @@
+package routes
+
+import (
+	"errors"
+	"habitat-server/models"
+	"habitat-server/storage"
+	"habitat-server/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var errInvalidSocialToken = errors.New("invalid social login token")
+
+var socialClient = &http.Client{Timeout: 10 * time.Second}
+
+// SocialProfile is what a login provider tells us about its user.
+type SocialProfile struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+}
+
+// socialLoginOrSignUp signs in the user linked to the profile's identity or
+// signs up a new one. A profile whose email belongs to an existing account is
+// never linked silently: the account's owner has to sign in and confirm it
+// with the link token, unless the account never verified its email, in which
+// case whoever registered it could not prove they own the address.
+func socialLoginOrSignUp(profile *SocialProfile, ctx iris.Context) {
+	identity, err := findIdentity(profile)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if identity != nil {
+		var user models.User
+		userExists := storage.DB.Find(&user, identity.UserID)
+
+		if userExists.Error != nil {
+			utils.CreateInternalServerError(ctx)
+			return
+		}
+
+		if userExists.RowsAffected == 0 {
+			utils.CreateError(iris.StatusNotFound, "Not Found", "User not found", ctx)
+			return
+		}
+
+		loginOrChallenge(user, ctx)
+		return
+	}
+
+	var user models.User
+	userExists, err := getAndHandleUserExists(&user, profile.Email)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if !userExists {
+		user = models.User{
+			FirstName:      profile.FirstName,
+			LastName:       profile.LastName,
+			Email:          strings.ToLower(profile.Email),
+			SocialLogin:    true,
+			SocialProvider: profile.Provider,
+			IsVerified:     &profile.EmailVerified,
+			MembershipTier: models.FreeTier,
+		}
+
+		err = storage.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+
+			return tx.Create(newIdentity(user.ID, profile)).Error
+		})
+
+		if err != nil {
+			utils.CreateInternalServerError(ctx)
+			return
+		}
+
+		returnUser(user, ctx)
+		return
+	}
+
+	if !profile.EmailVerified {
+		utils.CreateEmailAlreadyRegistered(ctx)
+		return
+	}
+
+	if user.IsVerified != nil && *user.IsVerified {
+		linkToken, err := utils.CreateAccountLinkToken(user.ID, profile.Provider, profile.Subject, strings.ToLower(profile.Email))
+		if err != nil {
+			utils.CreateInternalServerError(ctx)
+			return
+		}
+
+		utils.CreateAccountLinkRequired(linkToken, ctx)
+		return
+	}
+
+	// The account was registered with an address its creator never proved
+	// they own, and the provider says this user does. Hand the account over:
+	// drop the password and sign out any sessions the creator holds.
+	verified := true
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&user).Updates(map[string]interface{}{
+			"password":    "",
+			"is_verified": true,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(newIdentity(user.ID, profile)).Error
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if err := utils.RevokeAllSessions(user.ID); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	user.Password = ""
+	user.IsVerified = &verified
+	loginOrChallenge(user, ctx)
+}
+
+func GetIdentities(ctx iris.Context) {
+	user := getCurrentUser(ctx)
+	if user == nil {
+		return
+	}
+
+	var identities []models.Identity
+	if err := storage.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&identities).Error; err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"hasPassword": user.Password != "",
+		"identities":  identities,
+	})
+}
+
+// LinkIdentity links a provider account to the signed-in user, given a token
+// from that provider.
+func LinkIdentity(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var req LinkIdentityInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	var profile *SocialProfile
+	switch strings.ToLower(ctx.Params().Get("provider")) {
+	case "facebook":
+		profile, err = fetchFacebookProfile(req.Token)
+	case "google":
+		profile, err = fetchGoogleProfile(req.Token, req.Nonce)
+	case "apple":
+		profile, err = fetchAppleProfile(req.Token, req.Nonce)
+	default:
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	if !handleSocialProfileError(err, ctx) {
+		return
+	}
+
+	linkIdentity(claims.ID, profile, ctx)
+}
+
+// MergeIdentity links the identity from a social login that matched the
+// signed-in user's email.
+func MergeIdentity(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var req MergeIdentityInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	linkClaims, err := utils.ParseAccountLinkToken(req.LinkToken)
+	if err != nil {
+		utils.CreateError(iris.StatusUnauthorized, "Unauthorized", "Invalid or expired link token.", ctx)
+		return
+	}
+
+	if linkClaims.ID != claims.ID {
+		utils.CreateError(iris.StatusForbidden, "Forbidden", "The link token belongs to another account.", ctx)
+		return
+	}
+
+	linkIdentity(claims.ID, &SocialProfile{
+		Provider:      linkClaims.Provider,
+		Subject:       linkClaims.Subject,
+		Email:         linkClaims.Email,
+		EmailVerified: true,
+	}, ctx)
+}
+
+// UnlinkIdentity removes a linked provider as long as the user keeps some
+// way to sign in.
+func UnlinkIdentity(ctx iris.Context) {
+	user := getCurrentUser(ctx)
+	if user == nil {
+		return
+	}
+
+	var identity models.Identity
+	identityExists := storage.DB.Where("id = ? AND user_id = ?", ctx.Params().Get("id"), user.ID).Limit(1).Find(&identity)
+
+	if identityExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if identityExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	var otherIdentities int64
+	err := storage.DB.Model(&models.Identity{}).
+		Where("user_id = ? AND id <> ?", user.ID, identity.ID).
+		Count(&otherIdentities).Error
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if user.Password == "" && otherIdentities == 0 {
+		utils.CreateError(iris.StatusConflict, "Conflict", "Set a password or link another login before removing this one.", ctx)
+		return
+	}
+
+	if err := storage.DB.Unscoped().Delete(&identity).Error; err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// SetPassword sets or changes the signed-in user's password. Changing an
+// existing one requires the current password. Every other session is signed
+// out, and the caller gets a new token pair.
+func SetPassword(ctx iris.Context) {
+	user := getCurrentUser(ctx)
+	if user == nil {
+		return
+	}
+
+	var req SetPasswordInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	if user.Password != "" && bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)) != nil {
+		utils.CreateError(iris.StatusUnauthorized, "Credentials Error", "Incorrect current password.", ctx)
+		return
+	}
+
+	hashedPassword, err := hashAndSaltPassword(req.Password)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if err := storage.DB.Model(user).Update("password", hashedPassword).Error; err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if err := utils.RevokeAllSessions(user.ID); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	tokenPair, err := utils.StartSession(user, ctx)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"accessToken":  string(tokenPair.AccessToken),
+		"refreshToken": string(tokenPair.RefreshToken),
+	})
+}
+
+func linkIdentity(userID uint, profile *SocialProfile, ctx iris.Context) {
+	identity, err := findIdentity(profile)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if identity != nil && identity.UserID != userID {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This login is already linked to another account.", ctx)
+		return
+	}
+
+	if identity == nil {
+		identity = newIdentity(userID, profile)
+		if err := storage.DB.Create(identity).Error; err != nil {
+			utils.CreateInternalServerError(ctx)
+			return
+		}
+	}
+
+	ctx.StatusCode(iris.StatusCreated)
+	ctx.JSON(identity)
+}
+
+// findIdentity looks up the identity for a profile. Identities migrated from
+// the single social provider column are keyed on the email and get the
+// provider's subject the first time they are found.
+func findIdentity(profile *SocialProfile) (*models.Identity, error) {
+	var identity models.Identity
+	identityExists := storage.DB.Where("provider = ? AND subject = ?", profile.Provider, profile.Subject).Limit(1).Find(&identity)
+
+	if identityExists.Error != nil {
+		return nil, identityExists.Error
+	}
+
+	if identityExists.RowsAffected > 0 {
+		return &identity, nil
+	}
+
+	legacySubject := "legacy:" + strings.ToLower(profile.Email)
+	identityExists = storage.DB.Where("provider = ? AND subject = ?", profile.Provider, legacySubject).Limit(1).Find(&identity)
+
+	if identityExists.Error != nil || identityExists.RowsAffected == 0 {
+		return nil, identityExists.Error
+	}
+
+	if err := storage.DB.Model(&identity).Update("subject", profile.Subject).Error; err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func newIdentity(userID uint, profile *SocialProfile) *models.Identity {
+	return &models.Identity{
+		UserID:   userID,
+		Provider: profile.Provider,
+		Subject:  profile.Subject,
+		Email:    strings.ToLower(profile.Email),
+	}
+}
+
+// LinkIdentityInput carries a Facebook access token or a Google or Apple ID
+// token, with the nonce from CreateLoginNonce for the latter.
+type LinkIdentityInput struct {
+	Token string `json:"token" validate:"required"`
+	Nonce string `json:"nonce" validate:"max=256"`
+}
+
+type MergeIdentityInput struct {
+	LinkToken string `json:"linkToken" validate:"required"`
+}
+
+type SetPasswordInput struct {
+	CurrentPassword string `json:"currentPassword" validate:"max=256"`
+	Password        string `json:"password" validate:"required,min=8,max=256"`
+}
@@ -1,68 +1,47 @@
 package routes
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
-	"os"
+	"habitat-server/location"
+	"habitat-server/utils"
+	"strconv"
 
 	"github.com/kataras/iris/v12"
 )
 
 func Autocomplete(ctx iris.Context) {
-	limit := "10"
-	location := ctx.URLParam("location")
-	limitQuery := ctx.URLParam("limit")
-	if limitQuery != "" {
-		limit = limitQuery
+	query := ctx.URLParam("location")
+	if query == "" {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "location is required.", ctx)
+		return
 	}
 
-	apiKey := os.Getenv("LOCATION_TOKEN")
-	url :=
-		"https://api.locationiq.com/v1/autocomplete.php?key=" + apiKey + "&q=" + location + "&limit=" + limit
-
-	fetchLocations(url, ctx)
-}
-
-func Search(ctx iris.Context) {
-	location := ctx.URLParam("location")
-
-	apiKey := os.Getenv("LOCATION_TOKEN")
-	url :=
-		"https://api.locationiq.com/v1/search.php?key=" + apiKey + "&q=" + location + "&format=json&dedupe=1&addressdetails=1&matchquality=1&normalizeaddress=1&normalizecity=1"
-		
-	fetchLocations(url, ctx)
-}
+	limit, err := strconv.Atoi(ctx.URLParamDefault("limit", "10"))
+	if err != nil || limit < 1 || limit > 20 {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "limit must be between 1 and 20.", ctx)
+		return
+	}
 
-func fetchLocations(url string, ctx iris.Context) {
-	client := &http.Client{}
-	req, _ := http.NewRequest("GET", url, nil)
-	res, locationErr := client.Do(req)
-	if locationErr != nil {
-		ctx.StopWithProblem(iris.StatusInternalServerError, iris.NewProblem().
-			Title("Internal Server Error").
-			Detail("Internal Server Error"))
+	places, geocodeErr := location.Default.Autocomplete(ctx.Request().Context(), query, limit)
+	if geocodeErr != nil {
+		utils.CreateInternalServerError(ctx)
 		return
 	}
 
-	defer res.Body.Close()
+	ctx.JSON(places)
+}
 
-	body, bodyErr := ioutil.ReadAll(res.Body)
-	if bodyErr != nil {
-		ctx.StopWithProblem(iris.StatusInternalServerError, iris.NewProblem().
-			Title("Internal Server Error").
-			Detail("Internal Server Error"))
+func Search(ctx iris.Context) {
+	query := ctx.URLParam("location")
+	if query == "" {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "location is required.", ctx)
 		return
 	}
 
-	var objMap []map[string]interface{}
-	jsonErr := json.Unmarshal(body, &objMap)
-	if jsonErr != nil {
-		ctx.StopWithProblem(iris.StatusInternalServerError, iris.NewProblem().
-			Title("Internal Server Error").
-			Detail("Internal Server Error"))
+	places, geocodeErr := location.Default.Search(ctx.Request().Context(), query)
+	if geocodeErr != nil {
+		utils.CreateInternalServerError(ctx)
 		return
 	}
 
-	ctx.JSON(objMap)
-}
\ No newline at end of file
+	ctx.JSON(places)
+}
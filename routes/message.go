@@ -1,14 +1,51 @@
 package routes
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"habitat-server/models"
+	"habitat-server/scanner"
 	"habitat-server/storage"
 	"habitat-server/utils"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/cloudinary/cloudinary-go/v2/api"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/middleware/jwt"
 )
 
+const (
+	// maxAttachmentSize bounds a single attachment after base64 decoding.
+	maxAttachmentSize = 10 << 20
+	// maxAttachmentPixels rejects images that would take too much memory
+	// to thumbnail, however small the file.
+	maxAttachmentPixels = 40_000_000
+	// thumbnailSize is the longest side of image thumbnails.
+	thumbnailSize = 320
+	// attachmentURLLifetime is how long a signed download URL works.
+	attachmentURLLifetime = 5 * time.Minute
+)
+
+// attachmentTypes are the file types accepted in messages, keyed on the
+// sniffed content type; the type the client claims is not trusted.
+var attachmentTypes = map[string]string{
+	"image/jpeg":      api.Image.String(),
+	"image/png":       api.Image.String(),
+	"image/gif":       api.Image.String(),
+	"image/webp":      api.Image.String(),
+	"application/pdf": api.File,
+}
+
+var errAttachmentRejected = errors.New("attachment rejected")
+
+// CreateMessage sends a message with text, attachments or both. Attachments
+// are checked, scanned and stored before the message is saved.
 func CreateMessage(ctx iris.Context) {
 	var req CreateMessageInput
 
@@ -25,21 +62,230 @@ func CreateMessage(ctx iris.Context) {
 		return
 	}
 
+	if req.Text == "" && len(req.Attachments) == 0 {
+		utils.CreateError(iris.StatusUnprocessableEntity, "Empty Message", "Send some text or an attachment.", ctx)
+		return
+	}
+
+	var conversation models.Conversation
+	conversationExists := storage.DB.Find(&conversation, req.ConversationID)
+
+	if conversationExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if conversationExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	if !utils.Authorize(utils.CanAccessConversation(claims, conversation.TenantID, conversation.OwnerID), ctx) {
+		return
+	}
+
+	receiverID := conversation.OwnerID
+	if claims.ID == conversation.OwnerID {
+		receiverID = conversation.TenantID
+	}
+
+	if req.ReceiverID != receiverID {
+		utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Receiver", "The receiver must be the other participant.", ctx)
+		return
+	}
+
+	attachments := make([]models.MessageAttachment, 0, len(req.Attachments))
+	for _, input := range req.Attachments {
+		attachment, err := storeAttachment(ctx, conversation.ID, input)
+		if err == nil {
+			attachments = append(attachments, *attachment)
+			continue
+		}
+
+		deleteAttachments(attachments)
+		if !errors.Is(err, errAttachmentRejected) {
+			utils.CreateInternalServerError(ctx)
+		}
+		return
+	}
+
 	message := models.Message{
 		ConversationID: req.ConversationID,
 		SenderID:       req.SenderID,
 		ReceiverID:     req.ReceiverID,
 		Text:           req.Text,
+		Attachments:    attachments,
 	}
 
-	storage.DB.Create(&message)
+	if err := storage.DB.Create(&message).Error; err != nil {
+		deleteAttachments(attachments)
+		utils.CreateInternalServerError(ctx)
+		return
+	}
 
 	ctx.JSON(message)
 }
 
+// GetAttachmentURL signs a short-lived download URL for an attachment, or
+// its thumbnail with ?thumbnail=true, for the conversation's participants.
+func GetAttachmentURL(ctx iris.Context) {
+	conversation := getAccessibleConversation(ctx)
+	if conversation == nil {
+		return
+	}
+
+	params := ctx.Params()
+	attachmentID := params.Get("attachmentID")
+
+	var attachment models.MessageAttachment
+	attachmentExists := storage.DB.
+		Where("conversation_id = ?", conversation.ID).
+		Find(&attachment, attachmentID)
+
+	if attachmentExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if attachmentExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	publicID, format, resourceType := attachment.PublicID, attachment.Format, attachment.ResourceType
+	if thumbnail, _ := ctx.URLParamBool("thumbnail"); thumbnail {
+		if !attachment.HasThumbnail {
+			utils.CreateNotFound(ctx)
+			return
+		}
+
+		publicID, format, resourceType = attachment.ThumbnailPublicID, "jpg", api.Image.String()
+	}
+
+	expiresAt := time.Now().Add(attachmentURLLifetime)
+	url, err := storage.PrivateFileURL(publicID, format, resourceType, expiresAt)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"url":       url,
+		"expiresAt": expiresAt,
+	})
+}
+
+// storeAttachment validates, scans and uploads one attachment. Files that
+// fail a check get an error response and errAttachmentRejected.
+func storeAttachment(ctx iris.Context, conversationID uint, input AttachmentInput) (*models.MessageAttachment, error) {
+	encoded := input.Data
+	if _, data, found := strings.Cut(encoded, ","); found && strings.HasPrefix(encoded, "data:") {
+		encoded = data
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) == 0 {
+		utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Attachment", input.FileName+" is not valid base64.", ctx)
+		return nil, errAttachmentRejected
+	}
+
+	if len(data) > maxAttachmentSize {
+		utils.CreateError(iris.StatusRequestEntityTooLarge, "Attachment Too Large", input.FileName+" is larger than 10 MB.", ctx)
+		return nil, errAttachmentRejected
+	}
+
+	contentType := http.DetectContentType(data)
+	resourceType, allowed := attachmentTypes[contentType]
+	if !allowed {
+		utils.CreateError(iris.StatusUnsupportedMediaType, "Unsupported Attachment", input.FileName+" must be a JPEG, PNG, GIF, WebP or PDF.", ctx)
+		return nil, errAttachmentRejected
+	}
+
+	err = scanner.Default.Scan(ctx.Request().Context(), data)
+	if errors.Is(err, scanner.ErrInfected) {
+		log.Println("rejected attachment:", err)
+		utils.CreateError(iris.StatusUnprocessableEntity, "Attachment Rejected", input.FileName+" failed the virus scan.", ctx)
+		return nil, errAttachmentRejected
+	}
+
+	if err != nil {
+		log.Println("scanning attachment:", err)
+		utils.CreateError(iris.StatusServiceUnavailable, "Scanner Unavailable", "Attachments can't be checked right now. Try again later.", ctx)
+		return nil, errAttachmentRejected
+	}
+
+	attachment := models.MessageAttachment{
+		ConversationID: conversationID,
+		FileName:       path.Base(input.FileName),
+		ContentType:    contentType,
+		Size:           int64(len(data)),
+		ResourceType:   resourceType,
+	}
+
+	// WebP can't be decoded here, so it is sent without dimensions or a
+	// thumbnail.
+	var thumbnail []byte
+	if width, height, err := utils.ImageSize(data); err == nil {
+		if width*height > maxAttachmentPixels {
+			utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Attachment", input.FileName+" has too many pixels.", ctx)
+			return nil, errAttachmentRejected
+		}
+
+		attachment.Width, attachment.Height = width, height
+		if thumbnail, err = utils.Thumbnail(data, thumbnailSize); err != nil {
+			utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Attachment", input.FileName+" could not be read as an image.", ctx)
+			return nil, errAttachmentRejected
+		}
+	}
+
+	folder := "message/" + strconv.FormatUint(uint64(conversationID), 10)
+	uploaded, err := storage.UploadPrivateFile(ctx.Request().Context(), data, folder, resourceType)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment.PublicID = uploaded.PublicID
+	attachment.Format = uploaded.Format
+
+	if thumbnail != nil {
+		uploadedThumbnail, err := storage.UploadPrivateFile(ctx.Request().Context(), thumbnail, folder+"/thumbnail", api.Image.String())
+		if err != nil {
+			deleteAttachments([]models.MessageAttachment{attachment})
+			return nil, err
+		}
+
+		attachment.ThumbnailPublicID = uploadedThumbnail.PublicID
+		attachment.HasThumbnail = true
+	}
+
+	return &attachment, nil
+}
+
+// deleteAttachments removes the stored files of attachments that won't be
+// saved. Failures are only logged.
+func deleteAttachments(attachments []models.MessageAttachment) {
+	for _, attachment := range attachments {
+		if err := storage.DeletePrivateFile(context.Background(), attachment.PublicID, attachment.ResourceType); err != nil {
+			log.Println("deleting attachment:", err)
+		}
+
+		if attachment.HasThumbnail {
+			if err := storage.DeletePrivateFile(context.Background(), attachment.ThumbnailPublicID, api.Image.String()); err != nil {
+				log.Println("deleting attachment thumbnail:", err)
+			}
+		}
+	}
+}
+
 type CreateMessageInput struct {
-	ConversationID uint   `json:"conversationID" validate:"required"`
-	SenderID       uint   `json:"senderID" validate:"required"`
-	ReceiverID     uint   `json:"receiverID" validate:"required"`
-	Text           string `json:"text" validate:"required,lt=5000"`
+	ConversationID uint              `json:"conversationID" validate:"required"`
+	SenderID       uint              `json:"senderID" validate:"required"`
+	ReceiverID     uint              `json:"receiverID" validate:"required"`
+	Text           string            `json:"text" validate:"lt=5000"`
+	Attachments    []AttachmentInput `json:"attachments" validate:"max=5,dive"`
+}
+
+type AttachmentInput struct {
+	FileName string `json:"fileName" validate:"required,max=255"`
+	Data     string `json:"data" validate:"required"` // base64, optionally as a data URL
 }
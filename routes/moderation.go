@@ -0,0 +1,303 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"habitat-server/models"
+	"habitat-server/storage"
+	"habitat-server/utils"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errAlreadyDecided = errors.New("moderation item already decided")
+
+var defaultBannedWords = []string{
+	"wire transfer",
+	"western union",
+	"moneygram",
+	"gift card",
+	"no viewing",
+	"deposit before viewing",
+}
+
+func GetModerationQueue(ctx iris.Context) {
+	status := ctx.URLParamDefault("status", string(models.ModerationOpen))
+
+	var items []models.ModerationItem
+	itemsQuery := storage.DB.Preload("Property").
+		Where("status = ?", status).
+		Order("created_at ASC").
+		Find(&items)
+
+	if itemsQuery.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(items)
+}
+
+func GetModerationItem(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	item := getModerationItemByID(id, ctx)
+	if item == nil {
+		return
+	}
+
+	ctx.JSON(item)
+}
+
+func ApproveListing(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	item := getModerationItemByID(id, ctx)
+	if item == nil {
+		return
+	}
+
+	var req ModerationDecisionInput
+	err := ctx.ReadJSON(&req)
+	if err != nil && !iris.IsErrEmptyJSON(err) {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	decideModerationItem(item, models.ModerationApproved, req.Reason, ctx)
+}
+
+func RejectListing(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	item := getModerationItemByID(id, ctx)
+	if item == nil {
+		return
+	}
+
+	var req ModerationDecisionInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	if strings.TrimSpace(req.Reason) == "" {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "A reason is required to reject a listing.", ctx)
+		return
+	}
+
+	decideModerationItem(item, models.ModerationRejected, req.Reason, ctx)
+}
+
+func decideModerationItem(item *models.ModerationItem, status models.ModerationStatus, reason string, ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	now := time.Now()
+
+	listingStatus := models.ListingApproved
+	rejectionReason := ""
+	if status == models.ModerationRejected {
+		listingStatus = models.ListingRejected
+		rejectionReason = reason
+	}
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the item so two moderators deciding at once can't both win.
+		var current models.ModerationItem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, item.ID).Error; err != nil {
+			return err
+		}
+
+		if current.Status != models.ModerationOpen {
+			return errAlreadyDecided
+		}
+
+		itemUpdate := tx.Model(item).Updates(map[string]interface{}{
+			"status":       status,
+			"moderator_id": claims.ID,
+			"reason":       reason,
+			"decided_at":   now,
+		})
+		if itemUpdate.Error != nil {
+			return itemUpdate.Error
+		}
+
+		return tx.Model(&models.Property{}).Where("id = ?", item.PropertyID).Updates(map[string]interface{}{
+			"status":           listingStatus,
+			"rejection_reason": rejectionReason,
+		}).Error
+	})
+
+	if errors.Is(err, errAlreadyDecided) {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This listing has already been reviewed.", ctx)
+		return
+	}
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// submitForModeration puts a listing back into review and opens a queue item
+// carrying fresh pre-check results, reusing the open item if there is one.
+func submitForModeration(property *models.Property) error {
+	checks, err := json.Marshal(runListingChecks(property))
+	if err != nil {
+		return err
+	}
+
+	return storage.DB.Transaction(func(tx *gorm.DB) error {
+		statusUpdate := tx.Model(&models.Property{}).Where("id = ?", property.ID).
+			Update("status", models.ListingPendingReview)
+		if statusUpdate.Error != nil {
+			return statusUpdate.Error
+		}
+		property.Status = models.ListingPendingReview
+
+		var item models.ModerationItem
+		itemExists := tx.Where("property_id = ? AND status = ?", property.ID, models.ModerationOpen).
+			Limit(1).Find(&item)
+		if itemExists.Error != nil {
+			return itemExists.Error
+		}
+
+		if itemExists.RowsAffected > 0 {
+			return tx.Model(&item).Update("checks", checks).Error
+		}
+
+		return tx.Create(&models.ModerationItem{
+			PropertyID: property.ID,
+			Status:     models.ModerationOpen,
+			Checks:     checks,
+		}).Error
+	})
+}
+
+// runListingChecks performs the automated checks shown to moderators next
+// to a listing. They only inform the decision, nothing is rejected here.
+func runListingChecks(property *models.Property) []models.ModerationCheck {
+	return []models.ModerationCheck{
+		checkDuplicateAddress(property),
+		checkBannedWords(property),
+		checkPhotos(property),
+		{
+			Name:   "address_match",
+			Passed: !property.AddressMismatch,
+			Detail: property.NormalizedAddress,
+		},
+	}
+}
+
+func checkDuplicateAddress(property *models.Property) models.ModerationCheck {
+	check := models.ModerationCheck{Name: "duplicate_address", Passed: true}
+
+	var duplicates []models.Property
+	storage.DB.Select("id", "user_id").
+		Where("id != ?", property.ID).
+		Where(
+			storage.DB.Where("normalized_address <> '' AND normalized_address = ?", property.NormalizedAddress).
+				Or("lower(street) = lower(?) AND lower(city) = lower(?) AND zip = ?", property.Street, property.City, property.Zip),
+		).
+		Find(&duplicates)
+
+	if len(duplicates) > 0 {
+		check.Passed = false
+		ids := make([]string, 0, len(duplicates))
+		for _, duplicate := range duplicates {
+			ids = append(ids, strconv.FormatUint(uint64(duplicate.ID), 10))
+		}
+		check.Detail = "Same address as listing(s) " + strings.Join(ids, ", ")
+	}
+
+	return check
+}
+
+func checkBannedWords(property *models.Property) models.ModerationCheck {
+	check := models.ModerationCheck{Name: "banned_words", Passed: true}
+
+	bannedWords := defaultBannedWords
+	if configured := os.Getenv("BANNED_WORDS"); configured != "" {
+		bannedWords = strings.Split(configured, ",")
+	}
+
+	text := strings.ToLower(property.Name + " " + property.Description)
+	for _, apartment := range property.Apartments {
+		text += " " + strings.ToLower(apartment.Description)
+	}
+
+	var found []string
+	for _, word := range bannedWords {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word != "" && strings.Contains(text, word) {
+			found = append(found, word)
+		}
+	}
+
+	if len(found) > 0 {
+		check.Passed = false
+		check.Detail = "Contains: " + strings.Join(found, ", ")
+	}
+
+	return check
+}
+
+func checkPhotos(property *models.Property) models.ModerationCheck {
+	check := models.ModerationCheck{Name: "photos", Passed: true}
+
+	if hasImages(property.Images) {
+		return check
+	}
+
+	for _, apartment := range property.Apartments {
+		if hasImages(apartment.Images) {
+			return check
+		}
+	}
+
+	check.Passed = false
+	check.Detail = "Listing has no photos"
+	return check
+}
+
+func hasImages(images []byte) bool {
+	var urls []string
+	if len(images) == 0 || json.Unmarshal(images, &urls) != nil {
+		return false
+	}
+
+	return len(urls) > 0
+}
+
+func getModerationItemByID(id string, ctx iris.Context) *models.ModerationItem {
+	var item models.ModerationItem
+	itemExists := storage.DB.Preload("Property").Find(&item, id)
+
+	if itemExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return nil
+	}
+
+	if itemExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil
+	}
+
+	return &item
+}
+
+type ModerationDecisionInput struct {
+	Reason string `json:"reason" validate:"max=2000"`
+}
@@ -0,0 +1,142 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"habitat-server/location"
+	"habitat-server/models"
+	"habitat-server/storage"
+	"habitat-server/utils"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+const enrichmentCacheTTL = 24 * time.Hour
+
+func GetPropertyCommute(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	address := ctx.URLParam("address")
+	if address == "" {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "address is required.", ctx)
+		return
+	}
+
+	mode := ctx.URLParamDefault("mode", location.ModeDriving)
+	if !location.IsMode(mode) {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "mode must be driving, walking, cycling or transit.", ctx)
+		return
+	}
+
+	var property models.Property
+	propertyExists := storage.DB.Find(&property, id)
+
+	if propertyExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if propertyExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	reqCtx := ctx.Request().Context()
+	key := fmt.Sprintf("commute:%d:%s:%s", property.ID, mode, location.NormalizeQuery(address))
+
+	var commute location.Commute
+	err := cachedJSON(reqCtx, key, &commute, func() (interface{}, error) {
+		places, err := location.Default.Search(reqCtx, address)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(places) == 0 {
+			return nil, location.ErrAddressNotFound
+		}
+
+		from := location.Point{Lat: float64(property.Lat), Lng: float64(property.Lng)}
+		to := location.Point{Lat: places[0].Lat, Lng: places[0].Lng}
+
+		return location.EstimateCommute(reqCtx, location.Routing, from, to, mode)
+	})
+
+	if errors.Is(err, location.ErrAddressNotFound) {
+		utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Address", "The address could not be found.", ctx)
+		return
+	}
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(commute)
+}
+
+func getNeighborhood(ctx context.Context, property *models.Property) (*location.Neighborhood, error) {
+	key := fmt.Sprintf("neighborhood:%d", property.ID)
+
+	var neighborhood location.Neighborhood
+	err := cachedJSON(ctx, key, &neighborhood, func() (interface{}, error) {
+		lat := float64(property.Lat)
+		lng := float64(property.Lng)
+
+		pois, err := location.POIs.Nearby(ctx, lat, lng, location.DefaultNeighborhoodRadius)
+		if err != nil {
+			return nil, err
+		}
+
+		return location.SummarizeNeighborhood(pois, lat, lng, location.DefaultNeighborhoodRadius), nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &neighborhood, nil
+}
+
+// forgetPropertyLocation drops the cached neighborhood and commutes of a
+// property whose address moved, so they are computed again for the new one.
+func forgetPropertyLocation(ctx context.Context, propertyID uint) error {
+	keys := []string{fmt.Sprintf("neighborhood:%d", propertyID)}
+
+	commutes := storage.Redis.Scan(ctx, 0, fmt.Sprintf("commute:%d:*", propertyID), 100).Iterator()
+	for commutes.Next(ctx) {
+		keys = append(keys, commutes.Val())
+	}
+	if err := commutes.Err(); err != nil {
+		return err
+	}
+
+	return storage.Redis.Del(ctx, keys...).Err()
+}
+
+// cachedJSON reads key from Redis into dest, computing and storing the value
+// with fetch on a miss.
+func cachedJSON(ctx context.Context, key string, dest interface{}, fetch func() (interface{}, error)) error {
+	if body, err := storage.Redis.Get(ctx, key).Bytes(); err == nil {
+		if json.Unmarshal(body, dest) == nil {
+			return nil
+		}
+	}
+
+	value, err := fetch()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	storage.Redis.Set(ctx, key, body, enrichmentCacheTTL)
+
+	return json.Unmarshal(body, dest)
+}
@@ -0,0 +1,128 @@
+package routes
+
+import (
+	"context"
+	"habitat-server/email"
+	"habitat-server/models"
+	"habitat-server/storage"
+	"habitat-server/utils"
+	"log"
+	"net/url"
+	"os"
+
+	"github.com/kataras/iris/v12"
+)
+
+// RequestLoginCode emails a one-time code and magic link for signing in
+// without a password. Like ForgotPassword, it answers the same way whether
+// or not the email is registered and sends in the background.
+func RequestLoginCode(ctx iris.Context) {
+	var req LoginCodeRequestInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	var user models.User
+	userExists, err := getAndHandleUserExists(&user, req.Email)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if userExists && user.SuspendedAt == nil {
+		go sendLoginCodeEmail(user)
+	}
+
+	ctx.JSON(iris.Map{
+		"emailSent": true,
+	})
+}
+
+// VerifyLoginCode completes a passwordless login with the emailed code or
+// the token from its magic link. Failures count towards the same lockout as
+// password logins.
+func VerifyLoginCode(ctx iris.Context) {
+	var req LoginCodeInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	if lockout := utils.LoginLockout(req.Email); lockout > 0 {
+		utils.CreateTooManyRequests(lockout, ctx)
+		return
+	}
+
+	matched, err := utils.CheckLoginCode(req.Email, req.Code, req.Token)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	var user models.User
+	userExists, err := getAndHandleUserExists(&user, req.Email)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if !matched || !userExists {
+		utils.RecordLoginFailure(req.Email)
+		utils.CreateError(iris.StatusUnauthorized, "Credentials Error", "Invalid or expired code.", ctx)
+		return
+	}
+
+	utils.ClearLoginFailures(req.Email)
+
+	// Receiving the code proves the user owns the address. Whoever
+	// registered the account never did, so it is handed over the way
+	// socialLoginOrSignUp does: the password is dropped and any sessions
+	// the creator holds are signed out.
+	if user.IsVerified == nil || !*user.IsVerified {
+		verified := true
+		err := storage.DB.Model(&user).Updates(map[string]interface{}{
+			"password":    "",
+			"is_verified": true,
+		}).Error
+		if err != nil {
+			utils.CreateInternalServerError(ctx)
+			return
+		}
+
+		if err := utils.RevokeAllSessions(user.ID); err != nil {
+			utils.CreateInternalServerError(ctx)
+			return
+		}
+
+		user.Password = ""
+		user.IsVerified = &verified
+	}
+
+	loginOrChallenge(user, ctx)
+}
+
+func sendLoginCodeEmail(user models.User) {
+	code, token, err := utils.CreateLoginCode(user.Email)
+	if err == nil {
+		link := os.Getenv("PASSWORDLESS_LOGIN_URL") + "?email=" + url.QueryEscape(user.Email) + "&token=" + token
+		err = email.Send(context.Background(), user.Email, user.Locale,
+			email.PasswordlessLoginTemplate, email.PasswordlessLoginData{Code: code, Link: link})
+	}
+
+	if err != nil {
+		log.Println("login code email not sent to user", user.ID, err)
+	}
+}
+
+type LoginCodeRequestInput struct {
+	Email string `json:"email" validate:"required,max=256,email"`
+}
+
+type LoginCodeInput struct {
+	Email string `json:"email" validate:"required,max=256,email"`
+	Code  string `json:"code" validate:"required_without=Token,max=16"`
+	Token string `json:"token" validate:"required_without=Code,max=128"`
+}
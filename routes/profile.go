@@ -0,0 +1,298 @@
+package routes
+
+import (
+	"encoding/json"
+	"habitat-server/models"
+	"habitat-server/storage"
+	"habitat-server/utils"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+// responseRateWindow is how far back conversations count towards an owner's
+// response rate.
+const responseRateWindow = 90 * 24 * time.Hour
+
+// Profile is the part of a user shown to others. On the user's own profile
+// every field is filled in along with Visibility; on the public profile
+// fields the user keeps private are left out.
+type Profile struct {
+	AvatarURL    string          `json:"avatarURL,omitempty"`
+	Bio          string          `json:"bio,omitempty"`
+	Phone        string          `json:"phone,omitempty"`
+	Languages    []string        `json:"languages,omitempty"`
+	ResponseRate *float64        `json:"responseRate,omitempty"`
+	MemberSince  *time.Time      `json:"memberSince,omitempty"`
+	Visibility   map[string]bool `json:"visibility,omitempty"`
+}
+
+type ReviewStats struct {
+	Average float64 `json:"average"`
+	Count   int64   `json:"count"`
+}
+
+func UpdateProfile(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	user := getUserByID(id, ctx)
+	if user == nil {
+		return
+	}
+
+	var req UpdateProfileInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Bio != nil {
+		updates["bio"] = *req.Bio
+	}
+	if req.Phone != nil {
+		updates["phone"] = *req.Phone
+	}
+	if req.Languages != nil {
+		languages, _ := json.Marshal(*req.Languages)
+		updates["languages"] = languages
+	}
+	if req.Visibility != nil {
+		visibility := user.Visibility()
+		for field, public := range req.Visibility {
+			if !models.IsProfileField(field) {
+				utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Field", "Unknown profile field "+strconv.Quote(field)+".", ctx)
+				return
+			}
+			visibility[field] = public
+		}
+
+		visibilityJSON, _ := json.Marshal(visibility)
+		updates["profile_visibility"] = visibilityJSON
+	}
+
+	if len(updates) > 0 {
+		if err := storage.DB.Model(user).Updates(updates).Error; err != nil {
+			utils.CreateInternalServerError(ctx)
+			return
+		}
+	}
+
+	user = getUserByID(id, ctx)
+	if user == nil {
+		return
+	}
+
+	profile, err := buildProfile(*user, false)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(profile)
+}
+
+// UpdateAvatar uploads a new avatar and removes the one it replaces.
+func UpdateAvatar(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	user := getUserByID(id, ctx)
+	if user == nil {
+		return
+	}
+
+	var req UpdateAvatarInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	uploaded := storage.UploadBase64Image(req.Image, "avatar/"+id)
+	if uploaded == nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if err := storage.DB.Model(user).Update("avatar_url", uploaded["url"]).Error; err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	deleteAvatar(ctx, user.AvatarURL)
+
+	ctx.JSON(iris.Map{
+		"avatarURL": uploaded["url"],
+	})
+}
+
+func DeleteAvatar(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	user := getUserByID(id, ctx)
+	if user == nil {
+		return
+	}
+
+	if err := storage.DB.Model(user).Update("avatar_url", "").Error; err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	deleteAvatar(ctx, user.AvatarURL)
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// GetPublicProfile is an owner's public page: their public profile fields,
+// on-market listings and the stars their listings have been given.
+func GetPublicProfile(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	var user models.User
+	userExists := storage.DB.Where("id = ? AND suspended_at IS NULL", id).Limit(1).Find(&user)
+
+	if userExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if userExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	profile, err := buildProfile(user, true)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	var properties []models.Property
+	propertiesQuery := storage.DB.
+		Where("user_id = ? AND status = ? AND (on_market IS NULL OR on_market = true)", user.ID, models.ListingApproved).
+		Order("created_at DESC").
+		Find(&properties)
+
+	if propertiesQuery.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	var reviewStats ReviewStats
+	reviewStatsQuery := storage.DB.Model(&models.Review{}).
+		Select("COALESCE(AVG(reviews.stars), 0) AS average, COUNT(*) AS count").
+		Joins("JOIN properties ON properties.id = reviews.property_id AND properties.deleted_at IS NULL").
+		Where("properties.user_id = ?", user.ID).
+		Scan(&reviewStats)
+
+	if reviewStatsQuery.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"ID":          user.ID,
+		"firstName":   user.FirstName,
+		"lastName":    user.LastName,
+		"profile":     profile,
+		"properties":  properties,
+		"reviewStats": reviewStats,
+	})
+}
+
+// buildProfile assembles a user's profile, leaving out private fields when
+// it is for the public.
+func buildProfile(user models.User, public bool) (*Profile, error) {
+	visibility := user.Visibility()
+	shown := func(field string) bool {
+		return !public || visibility[field]
+	}
+
+	profile := &Profile{}
+	if shown(models.ProfileAvatar) {
+		profile.AvatarURL = user.AvatarURL
+	}
+	if shown(models.ProfileBio) {
+		profile.Bio = user.Bio
+	}
+	if shown(models.ProfilePhone) {
+		profile.Phone = user.Phone
+	}
+	if shown(models.ProfileLanguages) {
+		profile.Languages = user.LanguageList()
+	}
+	if shown(models.ProfileMemberSince) {
+		memberSince := user.CreatedAt
+		profile.MemberSince = &memberSince
+	}
+	if shown(models.ProfileResponseRate) {
+		rate, err := responseRate(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		profile.ResponseRate = rate
+	}
+	if !public {
+		profile.Visibility = visibility
+	}
+
+	return profile, nil
+}
+
+// responseRate is the share of recent conversations about the owner's
+// listings that the owner replied to, or nil without any.
+func responseRate(ownerID uint) (*float64, error) {
+	var counts struct {
+		Total     int64
+		Responded int64
+	}
+
+	err := storage.DB.Raw(`
+		SELECT COUNT(*) AS total,
+			COUNT(*) FILTER (WHERE EXISTS (
+				SELECT 1 FROM messages
+				WHERE messages.conversation_id = conversations.id
+					AND messages.sender_id = conversations.owner_id
+					AND messages.deleted_at IS NULL
+			)) AS responded
+		FROM conversations
+		WHERE owner_id = ? AND created_at > ? AND deleted_at IS NULL`,
+		ownerID, time.Now().Add(-responseRateWindow)).
+		Scan(&counts).Error
+
+	if err != nil || counts.Total == 0 {
+		return nil, err
+	}
+
+	rate := float64(counts.Responded) / float64(counts.Total)
+	return &rate, nil
+}
+
+func deleteAvatar(ctx iris.Context, avatarURL string) {
+	if avatarURL == "" {
+		return
+	}
+
+	if err := storage.DeleteImage(ctx.Request().Context(), avatarURL); err != nil {
+		log.Println("avatar", avatarURL, "not deleted:", err)
+	}
+}
+
+type UpdateProfileInput struct {
+	Bio        *string         `json:"bio" validate:"omitempty,max=1000"`
+	Phone      *string         `json:"phone" validate:"omitempty,e164|len=0"`
+	Languages  *[]string       `json:"languages" validate:"omitempty,max=10,dive,max=35"`
+	Visibility map[string]bool `json:"visibility"`
+}
+
+type UpdateAvatarInput struct {
+	Image string `json:"image" validate:"required,datauri,max=7000000"`
+}
@@ -0,0 +1,427 @@
+package routes
+
+import (
+	"habitat-server/models"
+	"habitat-server/ratings"
+	"habitat-server/storage"
+	"habitat-server/utils"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// maxSponsoredResults caps how many promoted listings lead a search page.
+	maxSponsoredResults = 2
+	// maxPromotionDuration bounds a single promotion so slots are not held
+	// indefinitely.
+	maxPromotionDuration = 90 * 24 * time.Hour
+	// defaultPromotionSpan is the half-width in degrees of the target area
+	// used when the owner does not choose one, roughly 5 km.
+	defaultPromotionSpan = 0.05
+	// defaultFrequencyCap is how many times a day one viewer sees the same
+	// promotion before it is shown as an ordinary result.
+	defaultFrequencyCap = 3
+	// impressionWindow is how long repeat searches by one viewer count as
+	// the same impression of a promotion.
+	impressionWindow = 30 * time.Minute
+)
+
+func CreatePromotion(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var req CreatePromotionInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	if !req.EndsAt.After(req.StartsAt) || !req.EndsAt.After(time.Now()) {
+		utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Schedule", "The promotion must end after it starts and in the future.", ctx)
+		return
+	}
+
+	if req.EndsAt.Sub(req.StartsAt) > maxPromotionDuration {
+		utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Schedule", "Promotions can run for at most 90 days.", ctx)
+		return
+	}
+
+	var property models.Property
+	propertyExists := storage.DB.Find(&property, req.PropertyID)
+
+	if propertyExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if propertyExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	if !utils.Authorize(utils.CanManageProperty(claims, &property), ctx) {
+		return
+	}
+
+	if property.Status != models.ListingApproved {
+		utils.CreateError(iris.StatusUnprocessableEntity, "Listing Not Approved", "Only approved listings can be promoted.", ctx)
+		return
+	}
+
+	entitlements, err := utils.UserEntitlements(property.UserID)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if !entitlements.FeaturedPlacement {
+		utils.CreateUpgradeRequired("featured placement", ctx)
+		return
+	}
+
+	// Slots are counted over promotions whose schedule overlaps the new one.
+	var usedSlots int64
+	err = storage.DB.Model(&models.Promotion{}).
+		Where("user_id = ? AND canceled_at IS NULL AND starts_at < ? AND ends_at > ?",
+			property.UserID, req.EndsAt, req.StartsAt).
+		Count(&usedSlots).Error
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if !models.WithinLimit(usedSlots, entitlements.PromotionSlots) {
+		utils.CreateUpgradeRequired("more promotion slots", ctx)
+		return
+	}
+
+	promotion := models.Promotion{
+		PropertyID: property.ID,
+		UserID:     property.UserID,
+		StartsAt:   req.StartsAt,
+		EndsAt:     req.EndsAt,
+		LatLow:     property.Lat - defaultPromotionSpan,
+		LatHigh:    property.Lat + defaultPromotionSpan,
+		LngLow:     property.Lng - defaultPromotionSpan,
+		LngHigh:    property.Lng + defaultPromotionSpan,
+	}
+
+	if req.TargetArea != nil {
+		promotion.LatLow = req.TargetArea.LatLow
+		promotion.LatHigh = req.TargetArea.LatHigh
+		promotion.LngLow = req.TargetArea.LngLow
+		promotion.LngHigh = req.TargetArea.LngHigh
+	}
+
+	if promotion.LatLow > promotion.LatHigh || promotion.LngLow > promotion.LngHigh {
+		utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Target Area", "The target area's low bounds must not exceed its high bounds.", ctx)
+		return
+	}
+
+	if err := storage.DB.Create(&promotion).Error; err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusCreated)
+	ctx.JSON(promotion)
+}
+
+func GetPromotions(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	var promotions []models.Promotion
+	promotionsQuery := storage.DB.Where("user_id = ?", claims.ID).
+		Order("starts_at DESC").
+		Find(&promotions)
+
+	if promotionsQuery.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	stats := make([]PromotionStats, 0, len(promotions))
+	for _, promotion := range promotions {
+		stats = append(stats, promotionStats(promotion))
+	}
+
+	ctx.JSON(stats)
+}
+
+func GetPromotion(ctx iris.Context) {
+	promotion := getManagedPromotion(ctx)
+	if promotion == nil {
+		return
+	}
+
+	ctx.JSON(promotionStats(*promotion))
+}
+
+func CancelPromotion(ctx iris.Context) {
+	promotion := getManagedPromotion(ctx)
+	if promotion == nil {
+		return
+	}
+
+	if promotion.CanceledAt == nil {
+		err := storage.DB.Model(promotion).Update("canceled_at", time.Now()).Error
+		if err != nil {
+			utils.CreateInternalServerError(ctx)
+			return
+		}
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// RecordPromotionClick counts a click on a sponsored result. Repeat clicks
+// from the same viewer within a day are counted once.
+func RecordPromotionClick(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	var promotion models.Promotion
+	promotionExists := storage.DB.Find(&promotion, id)
+
+	if promotionExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if promotionExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	clickKey := "promotion:click:" + id + ":" + utils.UserOrIPKey(ctx)
+	firstClick, err := storage.Redis.SetNX(ctx.Request().Context(), clickKey, "true", 24*time.Hour).Result()
+
+	if err == nil && firstClick && isPromotionRunning(promotion, time.Now()) {
+		storage.DB.Model(&promotion).UpdateColumn("clicks", gorm.Expr("clicks + 1"))
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// blendPromotions puts listings with a running promotion targeting the
+// searched area at the top of the results and marks them sponsored. A
+// promoted listing doesn't have to be among the organic results; it only
+// has to be approved and on the market. Each viewer sees the same promotion
+// a limited number of times a day; past that it stays in its organic
+// position, if it has one.
+func blendPromotions(ctx iris.Context, results []PropertySearchResult, box BoundingBoxInput, mean float64) []PropertySearchResult {
+	now := time.Now()
+	var promotions []models.Promotion
+	promotionsQuery := storage.DB.
+		Where("canceled_at IS NULL AND starts_at <= ? AND ends_at > ?", now, now).
+		Where("lat_low <= ? AND lat_high >= ? AND lng_low <= ? AND lng_high >= ?",
+			box.LatHigh, box.LatLow, box.LngHigh, box.LngLow).
+		Find(&promotions)
+
+	if promotionsQuery.Error != nil || len(promotions) == 0 {
+		return results
+	}
+
+	candidates := make(map[uint]PropertySearchResult, len(results))
+	for _, result := range results {
+		candidates[result.ID] = result
+	}
+
+	var missingIDs []uint
+	for _, promotion := range promotions {
+		if _, ok := candidates[promotion.PropertyID]; !ok {
+			missingIDs = append(missingIDs, promotion.PropertyID)
+		}
+	}
+
+	if len(missingIDs) > 0 {
+		var properties []models.Property
+		propertiesQuery := storage.DB.Preload(clause.Associations).
+			Preload("Reviews", "status = ?", models.ReviewPublished).
+			Where("id IN ? AND on_market = true AND status = ?", missingIDs, models.ListingApproved).
+			Find(&properties)
+
+		if propertiesQuery.Error != nil {
+			return results
+		}
+
+		for _, property := range properties {
+			candidates[property.ID] = PropertySearchResult{
+				Property:    property,
+				Display:     displayPrices(property, box.Currency),
+				RatingScore: ratings.Score(property.ReviewCount, property.StarSum, mean),
+			}
+		}
+	}
+
+	// Rotate which promotions win when more compete than there are slots.
+	rand.Shuffle(len(promotions), func(i, j int) {
+		promotions[i], promotions[j] = promotions[j], promotions[i]
+	})
+
+	viewer := utils.UserOrIPKey(ctx)
+
+	var sponsored []PropertySearchResult
+	var impressionIDs []uint
+	seen := map[uint]bool{}
+	for _, promotion := range promotions {
+		if len(sponsored) == maxSponsoredResults {
+			break
+		}
+
+		result, ok := candidates[promotion.PropertyID]
+		if !ok || seen[promotion.PropertyID] {
+			continue
+		}
+
+		show, impression := viewPromotion(ctx, promotion.ID, viewer, now)
+		if !show {
+			continue
+		}
+		if impression {
+			impressionIDs = append(impressionIDs, promotion.ID)
+		}
+
+		promotionID := promotion.ID
+		result.Sponsored = true
+		result.PromotionID = &promotionID
+
+		sponsored = append(sponsored, result)
+		seen[promotion.PropertyID] = true
+	}
+
+	if len(sponsored) == 0 {
+		return results
+	}
+
+	if len(impressionIDs) > 0 {
+		storage.DB.Model(&models.Promotion{}).
+			Where("id IN ?", impressionIDs).
+			UpdateColumn("impressions", gorm.Expr("impressions + 1"))
+	}
+
+	blended := make([]PropertySearchResult, 0, len(results)+len(sponsored))
+	blended = append(blended, sponsored...)
+	for _, result := range results {
+		if !seen[result.ID] {
+			blended = append(blended, result)
+		}
+	}
+
+	return blended
+}
+
+// viewPromotion decides whether the viewer is shown the promotion and
+// whether that counts as a new impression. Searches within
+// impressionWindow of a counted impression, like panning the map, are part
+// of it; each impression counts once towards the daily frequency cap.
+func viewPromotion(ctx iris.Context, promotionID uint, viewer string, now time.Time) (show bool, impression bool) {
+	requestCtx := ctx.Request().Context()
+	id := strconv.FormatUint(uint64(promotionID), 10)
+	frequencyKey := "promotion:frequency:" + id + ":" + viewer + ":" + now.UTC().Format("2006-01-02")
+	impressionKey := "promotion:impression:" + id + ":" + viewer
+
+	newImpression, err := storage.Redis.SetNX(requestCtx, impressionKey, "true", impressionWindow).Result()
+	if err != nil {
+		// Without Redis views can't be capped or deduplicated, so the
+		// promotion is shown but not counted.
+		return true, false
+	}
+
+	if !newImpression {
+		views, err := storage.Redis.Get(requestCtx, frequencyKey).Int64()
+		return err != nil || views <= promotionFrequencyCap(), false
+	}
+
+	views, err := storage.Redis.Incr(requestCtx, frequencyKey).Result()
+	if err != nil {
+		return true, false
+	}
+	if views == 1 {
+		storage.Redis.Expire(requestCtx, frequencyKey, 24*time.Hour)
+	}
+
+	if views > promotionFrequencyCap() {
+		return false, false
+	}
+
+	return true, true
+}
+
+func promotionFrequencyCap() int64 {
+	if frequencyCap, err := strconv.ParseInt(os.Getenv("PROMOTION_FREQUENCY_CAP"), 10, 64); err == nil && frequencyCap > 0 {
+		return frequencyCap
+	}
+
+	return defaultFrequencyCap
+}
+
+func isPromotionRunning(promotion models.Promotion, now time.Time) bool {
+	return promotion.CanceledAt == nil && !now.Before(promotion.StartsAt) && now.Before(promotion.EndsAt)
+}
+
+func promotionStats(promotion models.Promotion) PromotionStats {
+	var clickThroughRate float64
+	if promotion.Impressions > 0 {
+		clickThroughRate = float64(promotion.Clicks) / float64(promotion.Impressions)
+	}
+
+	return PromotionStats{
+		Promotion:        promotion,
+		Running:          isPromotionRunning(promotion, time.Now()),
+		ClickThroughRate: clickThroughRate,
+	}
+}
+
+func getManagedPromotion(ctx iris.Context) *models.Promotion {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	var promotion models.Promotion
+	promotionExists := storage.DB.Preload("Property").Find(&promotion, id)
+
+	if promotionExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return nil
+	}
+
+	if promotionExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	if !utils.Authorize(utils.CanManageProperty(claims, &promotion.Property), ctx) {
+		return nil
+	}
+
+	return &promotion
+}
+
+type CreatePromotionInput struct {
+	PropertyID uint                `json:"propertyID" validate:"required"`
+	StartsAt   time.Time           `json:"startsAt" validate:"required"`
+	EndsAt     time.Time           `json:"endsAt" validate:"required"`
+	TargetArea *PromotionAreaInput `json:"targetArea"`
+}
+
+type PromotionAreaInput struct {
+	LatLow  float32 `json:"latLow" validate:"min=-90,max=90"`
+	LatHigh float32 `json:"latHigh" validate:"min=-90,max=90"`
+	LngLow  float32 `json:"lngLow" validate:"min=-180,max=180"`
+	LngHigh float32 `json:"lngHigh" validate:"min=-180,max=180"`
+}
+
+type PromotionStats struct {
+	models.Promotion
+	Running          bool    `json:"running"`
+	ClickThroughRate float64 `json:"clickThroughRate"`
+}
@@ -3,9 +3,15 @@ package routes
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"habitat-server/location"
 	"habitat-server/models"
+	"habitat-server/money"
+	"habitat-server/ratings"
 	"habitat-server/storage"
 	"habitat-server/utils"
+	"log"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -15,10 +21,13 @@ import (
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/middleware/jwt"
 	"github.com/thanhpk/randstr"
+	"golang.org/x/exp/slices"
 	"gorm.io/gorm/clause"
 )
 
 func CreateProperty(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
 	var propertyInput CreatePropertyInput
 
 	err := ctx.ReadJSON(&propertyInput)
@@ -27,6 +36,10 @@ func CreateProperty(ctx iris.Context) {
 		return
 	}
 
+	if !canAddActiveListing(claims.ID, ctx) {
+		return
+	}
+
 	var apartments []models.Apartment
 	bedroomLow := 0
 	bedroomHigh := 0
@@ -56,13 +69,30 @@ func CreateProperty(ctx iris.Context) {
 		})
 	}
 
+	country := strings.ToUpper(propertyInput.Country)
+	if country == "" {
+		country = "US"
+	}
+
+	currency := strings.ToUpper(propertyInput.Currency)
+	if currency == "" {
+		currency = location.DefaultCurrency(country)
+	}
+
+	if !money.IsSupported(currency) {
+		utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Currency", "The currency is not supported.", ctx)
+		return
+	}
+
 	property := models.Property{
 		UnitType:     propertyInput.UnitType,
 		PropertyType: propertyInput.PropertyType,
 		Street:       propertyInput.Street,
 		City:         propertyInput.City,
 		State:        propertyInput.State,
-		Zip:          propertyInput.Zip,
+		Zip:          location.NormalizePostalCode(propertyInput.Zip),
+		Country:      country,
+		Currency:     currency,
 		Lat:          propertyInput.Lat,
 		Lng:          propertyInput.Lng,
 		BedroomLow:   bedroomLow,
@@ -70,10 +100,28 @@ func CreateProperty(ctx iris.Context) {
 		BathroomLow:  bathroomLow,
 		BathroomHigh: bathroomHigh,
 		Apartments:   apartments,
-		UserID:       propertyInput.UserID,
+		UserID:       claims.ID,
+		Status:       models.ListingPendingReview,
+	}
+
+	if !geocodeProperty(&property, ctx) {
+		return
+	}
+
+	propertyCreated := storage.DB.Create(&property)
+	if propertyCreated.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
 	}
 
-	storage.DB.Create(&property)
+	if err := submitForModeration(&property); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if claims.Role == models.TenantRole {
+		utils.PromoteToOwner(claims.ID)
+	}
 
 	ctx.JSON(property)
 }
@@ -87,7 +135,28 @@ func GetProperty(ctx iris.Context) {
 		return
 	}
 
-	ctx.JSON(property)
+	// Listings are only public once approved; owners and staff can still
+	// see them while in review.
+	if !utils.CanViewProperty(utils.Claims(ctx), property) {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	if !slices.Contains(strings.Split(ctx.URLParam("include"), ","), "neighborhood") {
+		ctx.JSON(property)
+		return
+	}
+
+	neighborhood, err := getNeighborhood(ctx.Request().Context(), property)
+	if err != nil {
+		// The listing is still useful without its surroundings.
+		log.Println("neighborhood unavailable for property", property.ID, err)
+	}
+
+	ctx.JSON(PropertyWithNeighborhood{
+		Property:     property,
+		Neighborhood: neighborhood,
+	})
 }
 
 func GetPropertiesByUserID(ctx iris.Context) {
@@ -121,8 +190,7 @@ func DeleteProperty(ctx iris.Context) {
 
 	claims := jwt.Get(ctx).(*utils.AccessToken)
 
-	if property.UserID != claims.ID {
-		ctx.StatusCode(iris.StatusForbidden)
+	if !utils.Authorize(utils.CanManageProperty(claims, &property), ctx) {
 		return
 	}
 
@@ -288,8 +356,14 @@ func UpdateProperty(ctx iris.Context) {
 
     claims := jwt.Get(ctx).(*utils.AccessToken)
 
-    if property.UserID != claims.ID {
-        ctx.StatusCode(iris.StatusForbidden)
+    if !utils.Authorize(utils.CanManageProperty(claims, property), ctx) {
+        return
+    }
+
+    // A takedown is an admin decision; editing the listing mustn't send it
+    // back into the ordinary review queue.
+    if property.Status == models.ListingTakenDown {
+        utils.CreateError(iris.StatusConflict, "Conflict", "This listing was taken down by a moderator and can't be edited.", ctx)
         return
     }
 
@@ -300,14 +374,74 @@ func UpdateProperty(ctx iris.Context) {
         return
     }
 
+    // Decided before the edits are applied, while property still holds what
+    // the moderators saw.
+    needsReview := propertyInput.needsReview(property)
+
+    // Relisting, or sending a rejected listing back into review, takes up
+    // one of the tier's active listings again.
+    if *propertyInput.OnMarket && !utils.IsActiveListing(property) && !canAddActiveListing(property.UserID, ctx) {
+        return
+    }
+
+    var apartmentIDs []uint
+    for _, apartment := range propertyInput.Apartments {
+        if apartment.ID != nil {
+            apartmentIDs = append(apartmentIDs, *apartment.ID)
+        }
+    }
+
+    if !ownsApartments(property, apartmentIDs, ctx) {
+        return
+    }
+
+    // Everything that can reject the request is checked before the
+    // apartments are written below.
+    if propertyInput.Currency != nil && !money.IsSupported(*propertyInput.Currency) {
+        utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Currency", "The currency is not supported.", ctx)
+        return
+    }
+
+    addressChanged := propertyInput.addressChanged(property)
+    if addressChanged {
+        if propertyInput.Street != nil {
+            property.Street = *propertyInput.Street
+        }
+        if propertyInput.City != nil {
+            property.City = *propertyInput.City
+        }
+        if propertyInput.State != nil {
+            property.State = *propertyInput.State
+        }
+        if propertyInput.Zip != nil {
+            property.Zip = location.NormalizePostalCode(*propertyInput.Zip)
+        }
+        if propertyInput.Country != nil {
+            property.Country = strings.ToUpper(*propertyInput.Country)
+        }
+        if propertyInput.Lat != nil && propertyInput.Lng != nil {
+            property.Lat = *propertyInput.Lat
+            property.Lng = *propertyInput.Lng
+        } else {
+            // The stored coordinates belong to the old address, so take the
+            // geocode's instead of flagging a mismatch against them.
+            property.Lat = 0
+            property.Lng = 0
+        }
+
+        if !geocodeProperty(property, ctx) {
+            return
+        }
+    }
+
     var newApartments []models.Apartment
     var newApartmentImages []*[]string
     bedroomLow := property.BedroomLow
     bedroomHigh := property.BedroomHigh
     var bathroomLow float32 = property.BathroomLow
     var bathroomHigh float32 = property.BathroomHigh
-    var rentLow float32 = propertyInput.Apartments[0].Rent
-    var rentHigh float32 = propertyInput.Apartments[0].Rent
+    rentLow := propertyInput.Apartments[0].Rent
+    rentHigh := propertyInput.Apartments[0].Rent
 
     for _, apartment := range propertyInput.Apartments {
         if apartment.Bathrooms < bathroomLow {
@@ -371,7 +505,9 @@ func UpdateProperty(ctx iris.Context) {
     property.IncludedUtilities = includedUtilities
     property.PetsAllowed = propertyInput.PetsAllowed
     property.LaundryType = propertyInput.LaundryType
-    property.ParkingFee = *propertyInput.ParkingFee
+    if propertyInput.ParkingFee != nil {
+        property.ParkingFee = *propertyInput.ParkingFee
+    }
     property.Amenities = propertyAmenities
     property.Name = propertyInput.Name
     property.FirstName = propertyInput.FirstName
@@ -389,6 +525,10 @@ func UpdateProperty(ctx iris.Context) {
     property.RentLow = rentLow
     property.RentHigh = rentHigh
 
+    if propertyInput.Currency != nil {
+        property.Currency = strings.ToUpper(*propertyInput.Currency)
+    }
+
     imagesArr := insertImages(InsertImages{
         images:     propertyInput.Images,
         propertyID: strconv.FormatUint(uint64(property.ID), 10),
@@ -407,6 +547,27 @@ func UpdateProperty(ctx iris.Context) {
         return
     }
 
+    // Updates skips zero values, so clearing the flag needs its own write.
+    if addressChanged && !property.AddressMismatch {
+        storage.DB.Model(&property).Update("address_mismatch", false)
+    }
+
+    if addressChanged {
+        if err := forgetPropertyLocation(ctx.Request().Context(), property.ID); err != nil {
+            log.Println("clearing location cache:", err)
+        }
+    }
+
+    // Approved listings stay live through edits moderators don't need to
+    // see, like rent or contact details. New text, photos or an address, and
+    // any edit to a listing not yet approved, go back into review.
+    if property.Status != models.ListingApproved || needsReview {
+        if err := submitForModeration(property); err != nil {
+            utils.CreateInternalServerError(ctx)
+            return
+        }
+    }
+
     ctx.StatusCode(iris.StatusNoContent)
 }
 
@@ -416,7 +577,9 @@ func UpdateProperty(ctx iris.Context) {
 func GetPropertyAndAssociationsByPropertyID(id string, ctx iris.Context) *models.Property {
 
 	var property models.Property
-	propertyExists := storage.DB.Preload(clause.Associations).Find(&property, id)
+	propertyExists := storage.DB.Preload(clause.Associations).
+		Preload("Reviews", "status = ?", models.ReviewPublished).
+		Find(&property, id)
 
 	if propertyExists.Error != nil {
 		utils.CreateInternalServerError(ctx)
@@ -431,6 +594,51 @@ func GetPropertyAndAssociationsByPropertyID(id string, ctx iris.Context) *models
 	return &property
 }
 
+// geocodeProperty resolves the property's address through the location
+// subsystem, moving it to the geocoded coordinates and flagging it when the
+// client supplied coordinates were too far off. It writes the error response
+// and returns false when the address is rejected.
+func geocodeProperty(property *models.Property, ctx iris.Context) bool {
+	if err := location.ValidatePostalAddress(property.Country, property.State, property.Zip); err != nil {
+		utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Address", err.Error(), ctx)
+		return false
+	}
+
+	address := location.AddressInput{
+		Street:     property.Street,
+		City:       property.City,
+		State:      property.State,
+		PostalCode: property.Zip,
+		Country:    property.Country,
+	}
+
+	geocoded, err := location.GeocodeAddress(ctx.Request().Context(), location.Default, address)
+	if errors.Is(err, location.ErrAddressNotFound) {
+		utils.CreateError(iris.StatusUnprocessableEntity, "Invalid Address", "The address could not be found.", ctx)
+		return false
+	}
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return false
+	}
+
+	property.AddressMismatch = false
+	if property.Lat != 0 || property.Lng != 0 {
+		distance := location.DistanceMeters(
+			float64(property.Lat), float64(property.Lng),
+			geocoded.Place.Lat, geocoded.Place.Lng)
+		property.AddressMismatch = distance > location.MismatchThresholdMeters()
+	}
+
+	property.Lat = float32(geocoded.Place.Lat)
+	property.Lng = float32(geocoded.Place.Lng)
+	property.NormalizedAddress = geocoded.Normalized
+	property.GeocodeConfidence = float32(geocoded.Confidence)
+
+	return true
+}
+
 func GetPropertiesByBoundingBox(ctx iris.Context) {
 	var boundingBox BoundingBoxInput
 	err := ctx.ReadJSON(&boundingBox)
@@ -441,11 +649,59 @@ func GetPropertiesByBoundingBox(ctx iris.Context) {
 
 	var properties []models.Property
 	storage.DB.Preload(clause.Associations).
-		Where("lat >= ? AND lat <= ? AND lng >= ? AND lng <= ? AND on_market = true",
-			boundingBox.LatLow, boundingBox.LatHigh, boundingBox.LngLow, boundingBox.LngHigh).
+		Preload("Reviews", "status = ?", models.ReviewPublished).
+		Where("lat >= ? AND lat <= ? AND lng >= ? AND lng <= ? AND on_market = true AND status = ?",
+			boundingBox.LatLow, boundingBox.LatHigh, boundingBox.LngLow, boundingBox.LngHigh, models.ListingApproved).
 		Find(&properties)
 
-	ctx.JSON(properties)
+	mean, err := ratings.PriorMean(storage.DB)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	results := make([]PropertySearchResult, 0, len(properties))
+	for _, property := range properties {
+		results = append(results, PropertySearchResult{
+			Property:    property,
+			Display:     displayPrices(property, boundingBox.Currency),
+			RatingScore: ratings.Score(property.ReviewCount, property.StarSum, mean),
+		})
+	}
+
+	if boundingBox.Sort == "rating" {
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].RatingScore > results[j].RatingScore
+		})
+	}
+
+	ctx.JSON(blendPromotions(ctx, results, boundingBox, mean))
+}
+
+// displayPrices formats a property's rent range, converted to the viewer's
+// currency when an exchange rate is known.
+func displayPrices(property models.Property, currency string) PriceDisplay {
+	display := PriceDisplay{
+		Currency: property.Currency,
+		RentLow:  property.RentLow,
+		RentHigh: property.RentHigh,
+	}
+
+	if currency != "" {
+		rentLow, lowErr := money.ExchangeRates.Convert(property.RentLow, property.Currency, currency)
+		rentHigh, highErr := money.ExchangeRates.Convert(property.RentHigh, property.Currency, currency)
+		if lowErr == nil && highErr == nil {
+			display.Currency = strings.ToUpper(currency)
+			display.RentLow = rentLow
+			display.RentHigh = rentHigh
+			display.Converted = display.Currency != property.Currency
+		}
+	}
+
+	display.FormattedRentLow = money.Format(display.RentLow, display.Currency)
+	display.FormattedRentHigh = money.Format(display.RentHigh, display.Currency)
+
+	return display
 }
 
 func insertImages(arg InsertImages, cld *cloudinary.Cloudinary) []string {
@@ -492,7 +748,16 @@ func updateApartmentAndImages(apartment models.Apartment, images []string, cld *
         apartment.Images = images
     }
 
-    storage.DB.Model(&apartment).Updates(apartment)
+    // Scoped to the property as well, so the update can't move a unit
+    // between listings.
+    storage.DB.Model(&models.Apartment{}).
+        Where("id = ? AND property_id = ?", apartment.ID, apartment.PropertyID).
+        Updates(apartment)
+}
+
+type PropertyWithNeighborhood struct {
+	*models.Property
+	Neighborhood *location.Neighborhood `json:"neighborhood"`
 }
 
 type InsertImages struct {
@@ -506,11 +771,12 @@ type CreatePropertyInput struct {
 	PropertyType string                 `json:"propertyType" validate:"required,max=256"`
 	Street       string                 `json:"street" validate:"required,max=512"`
 	City         string                 `json:"city" validate:"required,max=512"`
-	State        string                 `json:"state" validate:"required,max=256"`
-	Zip          int                    `json:"zip" validate:"required"`
-	Lat          float32                `json:"lat" validate:"required"`
-	Lng          float32                `json:"lng" validate:"required"`
-	UserID       uint                   `json:"userID" validate:"required"`
+	State        string                 `json:"state" validate:"max=256"`
+	Zip          string                 `json:"zip" validate:"required,max=32"`
+	Country      string                 `json:"country" validate:"omitempty,len=2"`
+	Currency     string                 `json:"currency" validate:"omitempty,len=3"`
+	Lat          float32                `json:"lat"`
+	Lng          float32                `json:"lng"`
 	Apartments   []CreateApartmentInput `json:"apartments" validate:"required,dive"`
 }
 
@@ -529,7 +795,7 @@ type UpdatePropertyInput struct {
 	IncludedUtilities []string                `json:"includedUtilities"`
 	PetsAllowed       string                  `json:"petsAllowed" validate:"required"`
 	LaundryType       string                  `json:"laundryType" validate:"required"`
-	ParkingFee        *float32                `json:"parkingFee"`
+	ParkingFee        *int64                  `json:"parkingFee" validate:"omitempty,gte=0"`
 	Amenities         []string                `json:"amenities"`
 	Name              string                  `json:"name"`
 	FirstName         string                  `json:"firstName"`
@@ -540,17 +806,100 @@ type UpdatePropertyInput struct {
 	PhoneNumber       string                  `json:"phoneNumber" validate:"required"`
 	Website           string                  `json:"website" validate:"omitempty,url"`
 	OnMarket          *bool                   `json:"onMarket" validate:"required"`
+	Street            *string                 `json:"street" validate:"omitempty,max=512"`
+	City              *string                 `json:"city" validate:"omitempty,max=512"`
+	State             *string                 `json:"state" validate:"omitempty,max=256"`
+	Zip               *string                 `json:"zip" validate:"omitempty,max=32"`
+	Country           *string                 `json:"country" validate:"omitempty,len=2"`
+	Currency          *string                 `json:"currency" validate:"omitempty,len=3"`
+	Lat               *float32                `json:"lat"`
+	Lng               *float32                `json:"lng"`
 	Apartments        []UpdateApartmentsInput `json:"apartments" validate:"required,dive"`
 }
 
+func (input UpdatePropertyInput) addressChanged(property *models.Property) bool {
+	return (input.Street != nil && *input.Street != property.Street) ||
+		(input.City != nil && *input.City != property.City) ||
+		(input.State != nil && *input.State != property.State) ||
+		(input.Zip != nil && location.NormalizePostalCode(*input.Zip) != property.Zip) ||
+		(input.Country != nil && !strings.EqualFold(*input.Country, property.Country))
+}
+
+// canAddActiveListing checks the user's tier allows one more active listing,
+// answering with Upgrade Required when it doesn't.
+func canAddActiveListing(userID uint, ctx iris.Context) bool {
+	entitlements, err := utils.UserEntitlements(userID)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return false
+	}
+
+	activeListings, err := utils.CountActiveListings(userID)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return false
+	}
+
+	if !models.WithinLimit(activeListings, entitlements.MaxActiveListings) {
+		utils.CreateUpgradeRequired("more active listings", ctx)
+		return false
+	}
+
+	return true
+}
+
+// needsReview reports whether the edit changes what moderators check: the
+// listing's text, its photos or its address.
+func (input UpdatePropertyInput) needsReview(property *models.Property) bool {
+	if input.Name != property.Name || input.Description != property.Description || input.addressChanged(property) {
+		return true
+	}
+
+	if hasNewImages(input.Images, property.Images) {
+		return true
+	}
+
+	apartments := make(map[uint]models.Apartment, len(property.Apartments))
+	for _, apartment := range property.Apartments {
+		apartments[apartment.ID] = apartment
+	}
+
+	for _, apartmentInput := range input.Apartments {
+		if apartmentInput.ID == nil {
+			return true
+		}
+
+		apartment, ok := apartments[*apartmentInput.ID]
+		if !ok || apartmentInput.Description != apartment.Description || hasNewImages(apartmentInput.Images, apartment.Images) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasNewImages reports whether images includes any not already stored.
+func hasNewImages(images []string, stored []byte) bool {
+	var storedURLs []string
+	json.Unmarshal(stored, &storedURLs)
+
+	for _, image := range images {
+		if !slices.Contains(storedURLs, image) {
+			return true
+		}
+	}
+
+	return false
+}
+
 type UpdateApartmentsInput struct {
 	ID          *uint     `json:"ID"`
 	Unit        string    `json:"unit" validate:"max=512"`
 	Bedrooms    *int      `json:"bedrooms" validate:"gte=0,max=6,required"` // make int a pointer so 0 is accepted
 	Bathrooms   float32   `json:"bathrooms" validate:"min=0.5,max=6.5,required"`
 	SqFt        int       `json:"sqFt" validate:"max=100000000000,required"`
-	Rent        float32   `json:"rent" validate:"required"`
-	Deposit     *float32  `json:"deposit" validate:"required"`
+	Rent        int64     `json:"rent" validate:"required,gt=0"`    // minor units
+	Deposit     *int64    `json:"deposit" validate:"required,gte=0"` // minor units
 	LeaseLength string    `json:"leaseLength" validate:"required,max=256"`
 	AvailableOn time.Time `json:"availableOn" validate:"required"`
 	Active      *bool     `json:"active" validate:"required"`
@@ -560,8 +909,27 @@ type UpdateApartmentsInput struct {
 }
 
 type BoundingBoxInput struct {
-	LatLow  float32 `json:"latLow" validate:"required"`
-	LatHigh float32 `json:"latHigh" validate:"required"`
-	LngLow  float32 `json:"lngLow" validate:"required"`
-	LngHigh float32 `json:"lngHigh" validate:"required"`
+	LatLow   float32 `json:"latLow" validate:"required"`
+	LatHigh  float32 `json:"latHigh" validate:"required"`
+	LngLow   float32 `json:"lngLow" validate:"required"`
+	LngHigh  float32 `json:"lngHigh" validate:"required"`
+	Currency string  `json:"currency" validate:"omitempty,len=3"` // display currency
+	Sort     string  `json:"sort" validate:"omitempty,oneof=rating"`
+}
+
+type PropertySearchResult struct {
+	models.Property
+	Display     PriceDisplay `json:"display"`
+	Sponsored   bool         `json:"sponsored"`
+	PromotionID *uint        `json:"promotionID,omitempty"`
+	RatingScore float64      `json:"ratingScore"` // Bayesian average used for ranking
+}
+
+type PriceDisplay struct {
+	Currency          string `json:"currency"`
+	RentLow           int64  `json:"rentLow"`
+	RentHigh          int64  `json:"rentHigh"`
+	FormattedRentLow  string `json:"formattedRentLow"`
+	FormattedRentHigh string `json:"formattedRentHigh"`
+	Converted         bool   `json:"converted"`
 }
\ No newline at end of file
@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"habitat-server/models"
 	"habitat-server/storage"
+	"habitat-server/utils"
+	"math"
 	"net/http"
 	"time"
 
 	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
 	"gorm.io/gorm"
 )
 
@@ -37,7 +40,7 @@ func CreateReservation(ctx iris.Context) {
 
     reservation.Status = "pending"
     reservation.PaymentStatus = "pending"
-    reservation.TotalPrice = calculateTotalPrice(&reservation)
+    reservation.TotalPrice, reservation.Currency = calculateTotalPrice(&reservation)
 
     if err := storage.DB.Create(&reservation).Error; err != nil {
         ctx.StatusCode(http.StatusInternalServerError)
@@ -69,7 +72,32 @@ func GetReservation(ctx iris.Context) {
         return
     }
 
-    ctx.JSON(reservation)
+    claims := jwt.Get(ctx).(*utils.AccessToken)
+    if !utils.CanViewReservation(claims, &reservation, &reservation.Property) {
+        ctx.StatusCode(http.StatusForbidden)
+        ctx.JSON(iris.Map{"error": "You don't have permission to view this reservation"})
+        return
+    }
+
+    // The tenant sees their whole profile; anyone else only what the tenant
+    // made public.
+    tenant := reservation.User
+    profile, err := buildProfile(tenant, claims.ID != tenant.ID)
+    if err != nil {
+        ctx.StatusCode(http.StatusInternalServerError)
+        ctx.JSON(iris.Map{"error": "Failed to retrieve reservation"})
+        return
+    }
+
+    ctx.JSON(ReservationResult{
+        Reservation: reservation,
+        User: ReservationParticipant{
+            ID:        tenant.ID,
+            FirstName: tenant.FirstName,
+            LastName:  tenant.LastName,
+            Profile:   profile,
+        },
+    })
 }
 
 func GetReservationsByUserID(ctx iris.Context) {
@@ -105,8 +133,8 @@ func UpdateReservation(ctx iris.Context) {
         return
     }
 
-    userID := ctx.Values().Get("userID").(uint)
-    if reservation.UserID != userID {
+    claims := jwt.Get(ctx).(*utils.AccessToken)
+    if !utils.CanManageReservation(claims, &reservation) {
         ctx.StatusCode(http.StatusForbidden)
         ctx.JSON(iris.Map{"error": "You don't have permission to update this reservation"})
         return
@@ -158,7 +186,7 @@ func UpdateReservation(ctx iris.Context) {
         return
     }
 
-    reservation.TotalPrice = calculateTotalPrice(&reservation)
+    reservation.TotalPrice, reservation.Currency = calculateTotalPrice(&reservation)
 
     if err := storage.DB.Save(&reservation).Error; err != nil {
         ctx.StatusCode(http.StatusInternalServerError)
@@ -189,8 +217,8 @@ func DeleteReservation(ctx iris.Context) {
         return
     }
 
-    userID := ctx.Values().Get("userID").(uint)
-    if reservation.UserID != userID {
+    claims := jwt.Get(ctx).(*utils.AccessToken)
+    if !utils.CanManageReservation(claims, &reservation) {
         ctx.StatusCode(http.StatusForbidden)
         ctx.JSON(iris.Map{"error": "You don't have permission to delete this reservation"})
         return
@@ -231,9 +259,23 @@ func checkAvailability(reservation *models.Reservation) error {
     return nil
 }
 
-func calculateTotalPrice(reservation *models.Reservation) float64 {
+func calculateTotalPrice(reservation *models.Reservation) (int64, string) {
     var property models.Property
     storage.DB.First(&property, reservation.PropertyID)
     days := reservation.EndDate.Sub(reservation.StartDate).Hours() / 24
-    return float64(property.RentHigh) * float64(days)
-}
\ No newline at end of file
+    return int64(math.Round(float64(property.RentHigh) * days)), property.Currency
+}
+
+// ReservationResult is a reservation with the tenant cut down to what the
+// viewer may see of them.
+type ReservationResult struct {
+    models.Reservation
+    User ReservationParticipant `json:"user"`
+}
+
+type ReservationParticipant struct {
+    ID        uint     `json:"ID"`
+    FirstName string   `json:"firstName"`
+    LastName  string   `json:"lastName"`
+    Profile   *Profile `json:"profile"`
+}
@@ -2,21 +2,37 @@ package routes
 
 import (
 	"habitat-server/models"
+	"habitat-server/ratings"
 	"habitat-server/storage"
 	"habitat-server/utils"
 	"math"
-	"strconv"
+	"time"
 
 	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// CreateReview posts the caller's review of a property. Only users who
+// stayed at the property or contacted its owner about it may review it, and
+// only once.
 func CreateReview(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
 	params := ctx.Params()
 	propertyID := params.Get("id")
 
-	property := GetPropertyAndAssociationsByPropertyID(propertyID, ctx)
+	var property models.Property
+	propertyExists := storage.DB.Find(&property, propertyID)
+
+	if propertyExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
 
-	if property == nil {
+	if propertyExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
 		return
 	}
 
@@ -27,49 +43,616 @@ func CreateReview(ctx iris.Context) {
 		return
 	}
 
-	propID, convErr := strconv.ParseUint(propertyID, 10, 32)
+	if property.UserID == claims.ID {
+		utils.CreateError(iris.StatusForbidden, "Forbidden", "You can't review your own listing.", ctx)
+		return
+	}
+
+	eligible, verifiedStay, err := reviewEligibility(claims.ID, property.ID)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if !eligible {
+		utils.CreateError(iris.StatusForbidden, "Not Eligible", "Only renters who stayed at or contacted this property can review it.", ctx)
+		return
+	}
+
+	// Deleted reviews count too, so deleting a bad review and posting a
+	// fresh one can't be used to shed reports and helpful votes, or to get
+	// round a moderator's removal.
+	var existingReviews int64
+	err = storage.DB.Unscoped().Model(&models.Review{}).
+		Where("user_id = ? AND property_id = ?", claims.ID, property.ID).
+		Count(&existingReviews).Error
 
-	if convErr != nil {
+	if err != nil {
 		utils.CreateInternalServerError(ctx)
 		return
 	}
 
+	if existingReviews > 0 {
+		utils.CreateError(iris.StatusConflict, "Conflict", "You have already reviewed this property.", ctx)
+		return
+	}
+
 	review := models.Review{
-		UserID:     reviewInput.UserID,
-		PropertyID: uint(propID),
-		Title:      reviewInput.Title,
-		Body:       reviewInput.Body,
-		Stars:      reviewInput.Stars,
+		UserID:        claims.ID,
+		PropertyID:    property.ID,
+		Title:         reviewInput.Title,
+		Body:          reviewInput.Body,
+		Stars:         reviewInput.Stars,
+		Cleanliness:   reviewInput.Cleanliness,
+		Communication: reviewInput.Communication,
+		Value:         reviewInput.Value,
+		Location:      reviewInput.Location,
+		Status:        models.ReviewPublished,
+		VerifiedStay:  verifiedStay,
+	}
+
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&review).Error; err != nil {
+			return err
+		}
+
+		return ratings.Track(tx, property.ID, models.Review{}, review)
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusCreated)
+	ctx.JSON(review)
+}
+
+// GetPropertyReviews pages through a property's published reviews. Sort by
+// newest (default), highest, lowest or helpful; pass the returned
+// nextCursor to get the following page. Every page carries the property's
+// rating summary.
+func GetPropertyReviews(ctx iris.Context) {
+	claims := utils.Claims(ctx)
+
+	params := ctx.Params()
+	propertyID := params.Get("id")
+
+	var property models.Property
+	propertyExists := storage.DB.Find(&property, propertyID)
+
+	if propertyExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if propertyExists.RowsAffected == 0 || !utils.CanViewProperty(claims, &property) {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	sortKey, ok := reviewSortKeys[ctx.URLParamDefault("sort", "newest")]
+	if !ok {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Sort by newest, highest, lowest or helpful.", ctx)
+		return
+	}
+
+	cursor, err := utils.DecodeCursor(ctx.URLParam("cursor"))
+	if err != nil {
+		utils.CreateError(iris.StatusBadRequest, "Bad Request", "Invalid cursor.", ctx)
+		return
+	}
+
+	limit := utils.PageLimit(ctx, 20, 100)
+
+	reviewsQuery := storage.DB.
+		Where("property_id = ? AND status = ?", property.ID, models.ReviewPublished).
+		Order(sortKey.order).
+		Limit(limit + 1)
+	if cursor != nil {
+		reviewsQuery = reviewsQuery.Where(sortKey.after, cursor.Key, cursor.Key, cursor.ID)
+	}
+
+	var reviews []models.Review
+	if err := reviewsQuery.Find(&reviews).Error; err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	var nextCursor *string
+	if len(reviews) > limit {
+		reviews = reviews[:limit]
+		last := reviews[limit-1]
+		next := utils.EncodeCursor(sortKey.key(last), last.ID)
+		nextCursor = &next
+	}
+
+	summary, err := reviewSummary(property.ID)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	results := make([]ReviewResult, 0, len(reviews))
+	votedHelpful := map[uint]bool{}
+	if claims != nil && len(reviews) > 0 {
+		reviewIDs := make([]uint, 0, len(reviews))
+		for _, review := range reviews {
+			reviewIDs = append(reviewIDs, review.ID)
+		}
+
+		var votedIDs []uint
+		err := storage.DB.Model(&models.ReviewHelpfulVote{}).
+			Where("user_id = ? AND review_id IN ?", claims.ID, reviewIDs).
+			Pluck("review_id", &votedIDs).Error
+		if err != nil {
+			utils.CreateInternalServerError(ctx)
+			return
+		}
+
+		for _, id := range votedIDs {
+			votedHelpful[id] = true
+		}
+	}
+
+	for _, review := range reviews {
+		results = append(results, ReviewResult{Review: review, VotedHelpful: votedHelpful[review.ID]})
+	}
+
+	ctx.JSON(iris.Map{
+		"reviews":    results,
+		"nextCursor": nextCursor,
+		"summary":    summary,
+	})
+}
+
+// MarkReviewHelpful records the caller's helpful vote. Voting twice has no
+// further effect.
+func MarkReviewHelpful(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	review := getReviewByID(ctx.Params().Get("id"), ctx)
+	if review == nil {
+		return
+	}
+
+	if review.UserID == claims.ID {
+		utils.CreateError(iris.StatusForbidden, "Forbidden", "You can't vote for your own review.", ctx)
+		return
+	}
+
+	if review.Status != models.ReviewPublished {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		voted := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.ReviewHelpfulVote{ReviewID: review.ID, UserID: claims.ID})
+		if voted.Error != nil || voted.RowsAffected == 0 {
+			return voted.Error
+		}
+
+		return tx.Model(review).UpdateColumn("helpful_count", gorm.Expr("helpful_count + 1")).Error
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+func UnmarkReviewHelpful(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	params := ctx.Params()
+	id := params.Get("id")
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		unvoted := tx.Unscoped().Where("review_id = ? AND user_id = ?", id, claims.ID).Delete(&models.ReviewHelpfulVote{})
+		if unvoted.Error != nil || unvoted.RowsAffected == 0 {
+			return unvoted.Error
+		}
+
+		return tx.Model(&models.Review{}).Where("id = ?", id).
+			UpdateColumn("helpful_count", gorm.Expr("GREATEST(helpful_count - 1, 0)")).Error
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// reviewSortKey orders reviews with the row ID as tie-breaker. after is the
+// condition for rows following a cursor, taking its key twice and its ID.
+type reviewSortKey struct {
+	order string
+	after string
+	key   func(review models.Review) int64
+}
+
+var reviewSortKeys = map[string]reviewSortKey{
+	"newest": {
+		order: "id DESC",
+		after: "(id < ? OR (id = ? AND id < ?))",
+		key:   func(review models.Review) int64 { return int64(review.ID) },
+	},
+	"highest": {
+		order: "stars DESC, id DESC",
+		after: "(stars < ? OR (stars = ? AND id < ?))",
+		key:   func(review models.Review) int64 { return int64(review.Stars) },
+	},
+	"lowest": {
+		order: "stars ASC, id DESC",
+		after: "(stars > ? OR (stars = ? AND id < ?))",
+		key:   func(review models.Review) int64 { return int64(review.Stars) },
+	},
+	"helpful": {
+		order: "helpful_count DESC, id DESC",
+		after: "(helpful_count < ? OR (helpful_count = ? AND id < ?))",
+		key:   func(review models.Review) int64 { return int64(review.HelpfulCount) },
+	},
+}
+
+// reviewSummary aggregates a property's published reviews: how many there
+// are, their average, a count per star and the average of each sub-rating
+// among the reviews that gave one.
+func reviewSummary(propertyID uint) (*ReviewSummary, error) {
+	var rows []struct {
+		Stars int
+		Count int64
+	}
+
+	err := storage.DB.Model(&models.Review{}).
+		Select("stars, COUNT(*) AS count").
+		Where("property_id = ? AND status = ?", propertyID, models.ReviewPublished).
+		Group("stars").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &ReviewSummary{Histogram: map[int]int64{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}}
+	var starSum int64
+	for _, row := range rows {
+		summary.Histogram[row.Stars] = row.Count
+		summary.Count += row.Count
+		starSum += int64(row.Stars) * row.Count
+	}
+
+	if summary.Count > 0 {
+		summary.Average = math.Round(float64(starSum)/float64(summary.Count)*10) / 10
+	}
+
+	err = storage.DB.Model(&models.Review{}).
+		Select("AVG(cleanliness) AS cleanliness, AVG(communication) AS communication, AVG(value) AS value, AVG(location) AS location").
+		Where("property_id = ? AND status = ?", propertyID, models.ReviewPublished).
+		Scan(&summary.SubRatings).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rating := range []*float64{summary.SubRatings.Cleanliness, summary.SubRatings.Communication, summary.SubRatings.Value, summary.SubRatings.Location} {
+		if rating != nil {
+			*rating = math.Round(*rating*10) / 10
+		}
+	}
+
+	return summary, nil
+}
+
+// UpdateReview edits the caller's review, keeping what it said before as a
+// revision.
+func UpdateReview(ctx iris.Context) {
+	review := getOwnReview(ctx)
+	if review == nil {
+		return
+	}
+
+	var req UpdateReviewInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	if review.Status == models.ReviewRemoved {
+		utils.CreateError(iris.StatusConflict, "Conflict", "This review was removed by a moderator.", ctx)
+		return
+	}
+
+	revision := models.ReviewRevision{
+		ReviewID: review.ID,
+		Title:    review.Title,
+		Body:     review.Body,
+		Stars:    review.Stars,
 	}
 
-	storage.DB.Create(&review)
+	updates := map[string]interface{}{"edited_at": time.Now()}
+	if req.Title != nil {
+		updates["title"] = *req.Title
+	}
+	if req.Body != nil {
+		updates["body"] = *req.Body
+	}
+	if req.Stars != nil {
+		updates["stars"] = *req.Stars
+	}
+	for column, rating := range map[string]*int{
+		"cleanliness":   req.Cleanliness,
+		"communication": req.Communication,
+		"value":         req.Value,
+		"location":      req.Location,
+	} {
+		if rating != nil {
+			updates[column] = *rating
+		}
+	}
+
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		before, err := lockReview(tx, review.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(review).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		after := before
+		after.Stars = review.Stars
+		return ratings.Track(tx, review.PropertyID, before, after)
+	})
 
-	updatePropertyStars(property, float32(review.Stars))
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
 
 	ctx.JSON(review)
 }
 
-func updatePropertyStars(property *models.Property, stars float32) {
-	var avg float32
-	reviewsLength := len(property.Reviews)
-	if reviewsLength == 0 {
-		avg = stars
-	} else {
-		var sum float32
-		for i := 0; i < len(property.Reviews); i++ {
-			sum += float32(property.Reviews[i].Stars)
+// DeleteReview withdraws the caller's review. Its revisions are kept.
+func DeleteReview(ctx iris.Context) {
+	review := getOwnReview(ctx)
+	if review == nil {
+		return
+	}
+
+	err := storage.DB.Transaction(func(tx *gorm.DB) error {
+		before, err := lockReview(tx, review.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Delete(review).Error; err != nil {
+			return err
 		}
 
-		avg = (sum + stars) / (float32(reviewsLength) + 1)
+		return ratings.Track(tx, review.PropertyID, before, models.Review{})
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+func GetReviewHistory(ctx iris.Context) {
+	review := getReviewByID(ctx.Params().Get("id"), ctx)
+	if review == nil {
+		return
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	if !utils.Authorize(utils.CanViewReviewHistory(claims, review), ctx) {
+		return
+	}
+
+	var revisions []models.ReviewRevision
+	revisionsQuery := storage.DB.Where("review_id = ?", review.ID).
+		Order("created_at DESC").
+		Find(&revisions)
+
+	if revisionsQuery.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"review":    review,
+		"revisions": revisions,
+	})
+}
+
+// RespondToReview sets the property owner's public response to a review,
+// replacing any earlier one.
+func RespondToReview(ctx iris.Context) {
+	review := getRespondableReview(ctx)
+	if review == nil {
+		return
+	}
+
+	var req ReviewResponseInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	err = storage.DB.Model(review).Updates(map[string]interface{}{
+		"owner_response":     req.Response,
+		"owner_responded_at": time.Now(),
+	}).Error
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(review)
+}
+
+func DeleteReviewResponse(ctx iris.Context) {
+	review := getRespondableReview(ctx)
+	if review == nil {
+		return
+	}
+
+	err := storage.DB.Model(review).Updates(map[string]interface{}{
+		"owner_response":     "",
+		"owner_responded_at": nil,
+	}).Error
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// reviewEligibility reports whether the user may review the property and
+// whether the review earns the verified stay badge, which takes a confirmed
+// reservation that has ended. A conversation with the owner about the
+// property is enough to review it without the badge.
+func reviewEligibility(userID uint, propertyID uint) (eligible bool, verifiedStay bool, err error) {
+	var stays int64
+	err = storage.DB.Model(&models.Reservation{}).
+		Where("user_id = ? AND property_id = ? AND status = ? AND end_date <= ?", userID, propertyID, "confirmed", time.Now()).
+		Count(&stays).Error
+
+	if err != nil {
+		return false, false, err
+	}
+
+	if stays > 0 {
+		return true, true, nil
 	}
 
-	avg = float32(math.Round(float64(avg)*10) / 10)
-	storage.DB.Model(&property).Update("stars", avg)
+	var conversations int64
+	err = storage.DB.Model(&models.Conversation{}).
+		Where("tenant_id = ? AND property_id = ?", userID, propertyID).
+		Count(&conversations).Error
+
+	return conversations > 0, false, err
+}
+
+// lockReview reloads a review in tx and holds its row until the transaction
+// ends, so concurrent changes to the same review apply their rating changes
+// one after another.
+func lockReview(tx *gorm.DB, id uint) (models.Review, error) {
+	var review models.Review
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&review, id).Error
+	return review, err
+}
+
+func getReviewByID(id string, ctx iris.Context) *models.Review {
+	var review models.Review
+	reviewExists := storage.DB.Find(&review, id)
+
+	if reviewExists.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return nil
+	}
+
+	if reviewExists.RowsAffected == 0 {
+		utils.CreateNotFound(ctx)
+		return nil
+	}
+
+	return &review
+}
+
+// getOwnReview loads the review in the path if the caller wrote it.
+func getOwnReview(ctx iris.Context) *models.Review {
+	review := getReviewByID(ctx.Params().Get("id"), ctx)
+	if review == nil {
+		return nil
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	if review.UserID != claims.ID {
+		utils.CreateNotFound(ctx)
+		return nil
+	}
+
+	return review
+}
+
+// getRespondableReview loads the review in the path if the caller manages
+// the reviewed property.
+func getRespondableReview(ctx iris.Context) *models.Review {
+	review := getReviewByID(ctx.Params().Get("id"), ctx)
+	if review == nil {
+		return nil
+	}
+
+	var property models.Property
+	if err := storage.DB.Find(&property, review.PropertyID).Error; err != nil {
+		utils.CreateInternalServerError(ctx)
+		return nil
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+	if !utils.Authorize(utils.CanManageProperty(claims, &property), ctx) {
+		return nil
+	}
+
+	return review
 }
 
 type CreateReviewInput struct {
-	UserID uint   `json:"userID" validate:"required"`
-	Title  string `json:"title" validate:"required"`
-	Body   string `json:"body" validate:"required"`
-	Stars  int    `json:"stars" validate:"required,gt=0,lt=6"`
+	Title         string `json:"title" validate:"required,max=256"`
+	Body          string `json:"body" validate:"required,max=5000"`
+	Stars         int    `json:"stars" validate:"required,gt=0,lt=6"`
+	Cleanliness   *int   `json:"cleanliness" validate:"omitempty,gt=0,lt=6"`
+	Communication *int   `json:"communication" validate:"omitempty,gt=0,lt=6"`
+	Value         *int   `json:"value" validate:"omitempty,gt=0,lt=6"`
+	Location      *int   `json:"location" validate:"omitempty,gt=0,lt=6"`
+}
+
+type UpdateReviewInput struct {
+	Title         *string `json:"title" validate:"omitempty,min=1,max=256"`
+	Body          *string `json:"body" validate:"omitempty,min=1,max=5000"`
+	Stars         *int    `json:"stars" validate:"omitempty,gt=0,lt=6"`
+	Cleanliness   *int    `json:"cleanliness" validate:"omitempty,gt=0,lt=6"`
+	Communication *int    `json:"communication" validate:"omitempty,gt=0,lt=6"`
+	Value         *int    `json:"value" validate:"omitempty,gt=0,lt=6"`
+	Location      *int    `json:"location" validate:"omitempty,gt=0,lt=6"`
+}
+
+type ReviewResponseInput struct {
+	Response string `json:"response" validate:"required,max=2000"`
+}
+
+type ReviewResult struct {
+	models.Review
+	VotedHelpful bool `json:"votedHelpful"`
+}
+
+type ReviewSummary struct {
+	Count      int64            `json:"count"`
+	Average    float64          `json:"average"`
+	Histogram  map[int]int64    `json:"histogram"`
+	SubRatings ReviewSubRatings `json:"subRatings"`
+}
+
+// ReviewSubRatings are nil when no review rated that aspect.
+type ReviewSubRatings struct {
+	Cleanliness   *float64 `json:"cleanliness"`
+	Communication *float64 `json:"communication"`
+	Value         *float64 `json:"value"`
+	Location      *float64 `json:"location"`
 }
@@ -0,0 +1,210 @@
+package routes
+
+import (
+	"habitat-server/models"
+	"habitat-server/ratings"
+	"habitat-server/storage"
+	"habitat-server/utils"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
+)
+
+// reviewHideThreshold is how many open reports hide a review until a
+// moderator looks at it.
+const reviewHideThreshold = 3
+
+// ReportReview flags a review for moderators. Each user can report a review
+// once.
+func ReportReview(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	review := getReviewByID(ctx.Params().Get("id"), ctx)
+	if review == nil {
+		return
+	}
+
+	var req ReportReviewInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	if review.UserID == claims.ID {
+		utils.CreateError(iris.StatusForbidden, "Forbidden", "You can't report your own review.", ctx)
+		return
+	}
+
+	var existingReports int64
+	err = storage.DB.Model(&models.ReviewReport{}).
+		Where("review_id = ? AND reporter_id = ?", review.ID, claims.ID).
+		Count(&existingReports).Error
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if existingReports > 0 {
+		utils.CreateError(iris.StatusConflict, "Conflict", "You have already reported this review.", ctx)
+		return
+	}
+
+	report := models.ReviewReport{
+		ReviewID:   review.ID,
+		ReporterID: claims.ID,
+		Reason:     req.Reason,
+		Detail:     req.Detail,
+		Status:     models.ModerationOpen,
+	}
+
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&report).Error; err != nil {
+			return err
+		}
+
+		var openReports int64
+		err := tx.Model(&models.ReviewReport{}).
+			Where("review_id = ? AND status = ?", review.ID, models.ModerationOpen).
+			Count(&openReports).Error
+		if err != nil || openReports < reviewHideThreshold {
+			return err
+		}
+
+		before, err := lockReview(tx, review.ID)
+		if err != nil || before.Status != models.ReviewPublished {
+			return err
+		}
+
+		if err := tx.Model(review).Update("status", models.ReviewHidden).Error; err != nil {
+			return err
+		}
+
+		after := before
+		after.Status = models.ReviewHidden
+		return ratings.Track(tx, review.PropertyID, before, after)
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusCreated)
+	ctx.JSON(report)
+}
+
+// GetReviewReports is the review moderation queue: reviews with reports in
+// the given status, open by default, oldest report first.
+func GetReviewReports(ctx iris.Context) {
+	status := ctx.URLParamDefault("status", string(models.ModerationOpen))
+
+	var reports []models.ReviewReport
+	reportsQuery := storage.DB.Preload("Review").
+		Where("status = ?", status).
+		Order("created_at ASC").
+		Find(&reports)
+
+	if reportsQuery.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	queue := []ReportedReview{}
+	positions := map[uint]int{}
+	for _, report := range reports {
+		position, seen := positions[report.ReviewID]
+		if !seen {
+			position = len(queue)
+			positions[report.ReviewID] = position
+			queue = append(queue, ReportedReview{Review: report.Review})
+		}
+
+		report.Review = models.Review{}
+		queue[position].Reports = append(queue[position].Reports, report)
+	}
+
+	ctx.JSON(queue)
+}
+
+// DismissReviewReports closes the open reports on a review and keeps it
+// published.
+func DismissReviewReports(ctx iris.Context) {
+	decideReviewReports(models.ModerationApproved, models.ReviewPublished, "review.reports_dismissed", ctx)
+}
+
+// RemoveReview takes a reported review down and closes its reports.
+func RemoveReview(ctx iris.Context) {
+	decideReviewReports(models.ModerationRejected, models.ReviewRemoved, "review.remove", ctx)
+}
+
+func decideReviewReports(decision models.ModerationStatus, reviewStatus models.ReviewStatus, action string, ctx iris.Context) {
+	review := getReviewByID(ctx.Params().Get("id"), ctx)
+	if review == nil {
+		return
+	}
+
+	var req ModerationDecisionInput
+	err := ctx.ReadJSON(&req)
+	if err != nil && !iris.IsErrEmptyJSON(err) {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		reportUpdate := tx.Model(&models.ReviewReport{}).
+			Where("review_id = ? AND status = ?", review.ID, models.ModerationOpen).
+			Updates(map[string]interface{}{
+				"status":       decision,
+				"moderator_id": claims.ID,
+				"decided_at":   time.Now(),
+			})
+		if reportUpdate.Error != nil {
+			return reportUpdate.Error
+		}
+
+		before, err := lockReview(tx, review.ID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(review).Update("status", reviewStatus).Error; err != nil {
+			return err
+		}
+
+		after := before
+		after.Status = reviewStatus
+		if err := ratings.Track(tx, review.PropertyID, before, after); err != nil {
+			return err
+		}
+
+		return utils.RecordAudit(tx, ctx, action, "review", review.ID, iris.Map{
+			"reason":        req.Reason,
+			"propertyID":    review.PropertyID,
+			"authorID":      review.UserID,
+			"reportsClosed": reportUpdate.RowsAffected,
+		})
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+type ReportReviewInput struct {
+	Reason models.ReviewReportReason `json:"reason" validate:"required,oneof=spam offensive irrelevant fake other"`
+	Detail string                    `json:"detail" validate:"max=1000"`
+}
+
+type ReportedReview struct {
+	Review  models.Review         `json:"review"`
+	Reports []models.ReviewReport `json:"reports"`
+}
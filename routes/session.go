@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"errors"
+	"habitat-server/utils"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+)
+
+func GetSessions(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	sessions, err := utils.ListSessions(claims.ID)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	currentSessionID := utils.CurrentSessionID(ctx)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+
+	ctx.JSON(sessions)
+}
+
+func RevokeSession(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	params := ctx.Params()
+	sessionID := params.Get("id")
+
+	ownerID, err := utils.SessionOwner(sessionID)
+	if errors.Is(err, utils.ErrSessionRevoked) || (err == nil && ownerID != claims.ID) {
+		utils.CreateNotFound(ctx)
+		return
+	}
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if err := utils.RevokeSession(claims.ID, sessionID); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+func RevokeAllSessions(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	if err := utils.RevokeAllSessions(claims.ID); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+// Logout revokes the session of the access token making the request.
+func Logout(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.AccessToken)
+
+	sessionID := utils.CurrentSessionID(ctx)
+	if sessionID == "" {
+		// Tokens from before sessions existed can only be signed out
+		// everywhere at once.
+		if err := utils.RevokeAllSessions(claims.ID); err != nil {
+			utils.CreateInternalServerError(ctx)
+			return
+		}
+
+		ctx.StatusCode(iris.StatusNoContent)
+		return
+	}
+
+	if err := utils.RevokeSession(claims.ID, sessionID); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
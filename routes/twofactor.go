@@ -0,0 +1,412 @@
+package routes
+
+import (
+	"habitat-server/models"
+	"habitat-server/storage"
+	"habitat-server/totp"
+	"habitat-server/utils"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// EnrollTwoFactor starts enrollment by generating a secret. Two-factor is
+// not enabled until ConfirmTwoFactor sees a valid code for it.
+//
+// No QR image is returned. Clients render otpauthURI as a QR code themselves,
+// or open it directly when the authenticator is on the same device, and show
+// secret for typing in by hand. Keeping the secret out of an image URL also
+// keeps it out of any image cache.
+func EnrollTwoFactor(ctx iris.Context) {
+	user := getCurrentUser(ctx)
+	if user == nil {
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		utils.CreateError(iris.StatusConflict, "Conflict", "Two-factor authentication is already enabled.", ctx)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	sealed, err := utils.SealTOTPSecret(secret)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if err := storage.DB.Model(user).Update("two_factor_secret", sealed).Error; err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	issuer := os.Getenv("TWO_FACTOR_ISSUER")
+	if issuer == "" {
+		issuer = "Habitat"
+	}
+
+	ctx.JSON(iris.Map{
+		"secret":     secret,
+		"otpauthURI": totp.URI(issuer, user.Email, secret),
+	})
+}
+
+// ConfirmTwoFactor enables two-factor once the user proves their
+// authenticator works. Sessions started before this are signed out, and the
+// caller gets a new token pair along with the recovery codes, which are only
+// ever shown here.
+func ConfirmTwoFactor(ctx iris.Context) {
+	user := getCurrentUser(ctx)
+	if user == nil {
+		return
+	}
+
+	var req TwoFactorCodeInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	if user.TwoFactorEnabled || user.TwoFactorSecret == "" {
+		utils.CreateError(iris.StatusConflict, "Conflict", "Start two-factor enrollment first.", ctx)
+		return
+	}
+
+	if ok, err := verifyTOTP(user, req.Code); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	} else if !ok {
+		utils.CreateError(iris.StatusUnauthorized, "Credentials Error", "Invalid code.", ctx)
+		return
+	}
+
+	var codes []string
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("two_factor_enabled", true).Error; err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if err := utils.RevokeAllSessions(user.ID); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	user.TwoFactorEnabled = true
+	tokenPair, err := utils.StartSession(user, ctx)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"recoveryCodes": codes,
+		"accessToken":   string(tokenPair.AccessToken),
+		"refreshToken":  string(tokenPair.RefreshToken),
+	})
+}
+
+func DisableTwoFactor(ctx iris.Context) {
+	user := getCurrentUser(ctx)
+	if user == nil {
+		return
+	}
+
+	var req SecondFactorInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	if !user.TwoFactorEnabled {
+		utils.CreateError(iris.StatusConflict, "Conflict", "Two-factor authentication is not enabled.", ctx)
+		return
+	}
+
+	if utils.TwoFactorMandatory(user.Role, user.TwoFactorRequired) {
+		utils.CreateError(iris.StatusForbidden, "Forbidden", "Two-factor authentication is required for this account.", ctx)
+		return
+	}
+
+	if !checkSecondFactor(user, req, ctx) {
+		return
+	}
+
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		return clearTwoFactor(tx, user.ID)
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
+func RegenerateRecoveryCodes(ctx iris.Context) {
+	user := getCurrentUser(ctx)
+	if user == nil {
+		return
+	}
+
+	var req TwoFactorCodeInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	if !user.TwoFactorEnabled {
+		utils.CreateError(iris.StatusConflict, "Conflict", "Two-factor authentication is not enabled.", ctx)
+		return
+	}
+
+	if ok, err := verifyTOTP(user, req.Code); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	} else if !ok {
+		utils.CreateError(iris.StatusUnauthorized, "Credentials Error", "Invalid code.", ctx)
+		return
+	}
+
+	var codes []string
+	err = storage.DB.Transaction(func(tx *gorm.DB) error {
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"recoveryCodes": codes,
+	})
+}
+
+// LoginTwoFactor completes a login that Login answered with a challenge.
+func LoginTwoFactor(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*utils.TwoFactorChallengeToken)
+
+	var req SecondFactorInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	user := getUserByID(strconv.FormatUint(uint64(claims.ID), 10), ctx)
+	if user == nil {
+		return
+	}
+
+	if !user.TwoFactorEnabled {
+		utils.CreateError(iris.StatusUnauthorized, "Credentials Error", "Invalid challenge.", ctx)
+		return
+	}
+
+	if lockout := utils.LoginLockout(user.Email); lockout > 0 {
+		utils.CreateTooManyRequests(lockout, ctx)
+		return
+	}
+
+	if !checkSecondFactor(user, req, ctx) {
+		utils.RecordLoginFailure(user.Email)
+		return
+	}
+
+	utils.ClearLoginFailures(user.Email)
+	returnUser(*user, ctx)
+}
+
+// loginOrChallenge finishes a first-factor login: users with two-factor get
+// a challenge token to exchange at LoginTwoFactor, users who must use it but
+// haven't enrolled an enrollment token, everyone else their tokens.
+func loginOrChallenge(user models.User, ctx iris.Context) {
+	if user.SuspendedAt != nil {
+		utils.CreateAccountSuspended(ctx)
+		return
+	}
+
+	// Users who must use two-factor and haven't set it up only get a token
+	// to enroll with; confirming enrollment signs them in.
+	if !user.TwoFactorEnabled && utils.TwoFactorMandatory(user.Role, user.TwoFactorRequired) {
+		enrollmentToken, err := utils.CreateTwoFactorEnrollmentToken(user.ID)
+		if err != nil {
+			utils.CreateInternalServerError(ctx)
+			return
+		}
+
+		ctx.JSON(iris.Map{
+			"twoFactorEnrollmentRequired": true,
+			"enrollmentToken":             enrollmentToken,
+		})
+		return
+	}
+
+	if !user.TwoFactorEnabled {
+		returnUser(user, ctx)
+		return
+	}
+
+	challengeToken, err := utils.CreateTwoFactorChallengeToken(user.ID)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"twoFactorRequired": true,
+		"challengeToken":    challengeToken,
+	})
+}
+
+// checkSecondFactor accepts either a TOTP code or an unused recovery code,
+// writing an unauthorized response when neither matches.
+func checkSecondFactor(user *models.User, req SecondFactorInput, ctx iris.Context) bool {
+	var ok bool
+	var err error
+
+	switch {
+	case req.Code != "":
+		ok, err = verifyTOTP(user, req.Code)
+	case req.RecoveryCode != "":
+		ok, err = useRecoveryCode(user.ID, req.RecoveryCode)
+	}
+
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return false
+	}
+
+	if !ok {
+		utils.CreateError(iris.StatusUnauthorized, "Credentials Error", "Invalid code.", ctx)
+		return false
+	}
+
+	return true
+}
+
+// verifyTOTP checks a code and records its time step, so the same code
+// can't be used twice even within its validity window.
+func verifyTOTP(user *models.User, code string) (bool, error) {
+	secret, err := utils.OpenTOTPSecret(user.TwoFactorSecret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	stepRecorded := storage.DB.Model(&models.User{}).
+		Where("id = ? AND two_factor_last_step < ?", user.ID, step).
+		Update("two_factor_last_step", step)
+
+	return stepRecorded.RowsAffected == 1, stepRecorded.Error
+}
+
+func useRecoveryCode(userID uint, code string) (bool, error) {
+	var recoveryCodes []models.RecoveryCode
+	err := storage.DB.Where("user_id = ? AND used_at IS NULL", userID).Find(&recoveryCodes).Error
+	if err != nil {
+		return false, err
+	}
+
+	code = utils.NormalizeRecoveryCode(code)
+	for _, recoveryCode := range recoveryCodes {
+		if bcrypt.CompareHashAndPassword([]byte(recoveryCode.CodeHash), []byte(code)) != nil {
+			continue
+		}
+
+		codeUsed := storage.DB.Model(&recoveryCode).
+			Where("used_at IS NULL").
+			Update("used_at", time.Now())
+
+		return codeUsed.RowsAffected == 1, codeUsed.Error
+	}
+
+	return false, nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes, err := utils.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes := make([]models.RecoveryCode, 0, len(codes))
+	for _, code := range codes {
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+
+		recoveryCodes = append(recoveryCodes, models.RecoveryCode{UserID: userID, CodeHash: string(hash)})
+	}
+
+	return codes, tx.Create(&recoveryCodes).Error
+}
+
+func clearTwoFactor(tx *gorm.DB, userID uint) error {
+	err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"two_factor_enabled":   false,
+		"two_factor_secret":    "",
+		"two_factor_last_step": 0,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
+
+// getCurrentUser loads the user of the request's access token, or of its
+// enrollment token on the routes that accept one.
+func getCurrentUser(ctx iris.Context) *models.User {
+	var userID uint
+	switch claims := jwt.Get(ctx).(type) {
+	case *utils.AccessToken:
+		userID = claims.ID
+	case *utils.TwoFactorEnrollmentToken:
+		userID = claims.ID
+	}
+
+	return getUserByID(strconv.FormatUint(uint64(userID), 10), ctx)
+}
+
+type TwoFactorCodeInput struct {
+	Code string `json:"code" validate:"required,max=16"`
+}
+
+type SecondFactorInput struct {
+	Code         string `json:"code" validate:"required_without=RecoveryCode,max=16"`
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code,max=32"`
+}
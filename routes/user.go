@@ -1,25 +1,31 @@
 package routes
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"habitat-server/email"
 	"habitat-server/models"
+	"habitat-server/oidc"
 	"habitat-server/storage"
 	"habitat-server/utils"
 	"io/ioutil"
 	"log"
-	"net/http"
+	"net/url"
+	"os"
 	"strconv"
 	"strings"
 
-	"github.com/MicahParks/keyfunc"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/kataras/iris/v12"
 	jsonWT "github.com/kataras/iris/v12/middleware/jwt"
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/exp/slices"
 )
 
+// dummyPasswordHash is compared against when a login has no password hash
+// to check, so failed logins take the same time either way.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
 func Register(ctx iris.Context) {
 	var userInput RegisterUserInput
 	err := ctx.ReadJSON(&userInput)
@@ -53,10 +59,16 @@ func Register(ctx iris.Context) {
 		Password:       hashedPassword,
 		SocialLogin:    false,
 		MembershipTier: models.FreeTier, // Set default tier to Free
+		Locale:         email.SupportedLocale(userInput.Locale + "," + ctx.GetHeader("Accept-Language")),
 	}
 
 	storage.DB.Create(&newUser)
 
+	// A failed send shouldn't block sign up; the user can ask for a resend.
+	if err := sendVerificationEmail(&newUser); err != nil {
+		log.Println("verification email not sent to user", newUser.ID, err)
+	}
+
 	returnUser(newUser, ctx)
 }
 
@@ -68,6 +80,11 @@ func Login(ctx iris.Context) {
 		return
 	}
 
+	if lockout := utils.LoginLockout(userInput.Email); lockout > 0 {
+		utils.CreateTooManyRequests(lockout, ctx)
+		return
+	}
+
 	var existingUser models.User
 	errorMsg := "Invalid email or password."
 	userExists, userExistsErr := getAndHandleUserExists(&existingUser, userInput.Email)
@@ -76,141 +93,71 @@ func Login(ctx iris.Context) {
 		return
 	}
 
-	if userExists == false {
-		utils.CreateError(iris.StatusUnauthorized, "Credentials Error", errorMsg, ctx)
-		return
+	// Unknown emails and accounts without a password are checked against a
+	// dummy hash and get the same error, so neither the response nor its
+	// timing reveals which emails are registered.
+	hasPassword := userExists && existingUser.Password != ""
+	passwordHash := dummyPasswordHash
+	if hasPassword {
+		passwordHash = []byte(existingUser.Password)
 	}
 
-	// Questionable as to whether you should let userInput know they logged in with Oauth
-	// typically the fewer things said the better
-	// If you don't want this, simply comment it out and the app will still work
-	if existingUser.SocialLogin == true {
-		utils.CreateError(iris.StatusUnauthorized, "Credentials Error", "Social Login Account", ctx)
-		return
-	}
-
-	passwordErr := bcrypt.CompareHashAndPassword([]byte(existingUser.Password), []byte(userInput.Password))
-	if passwordErr != nil {
+	passwordErr := bcrypt.CompareHashAndPassword(passwordHash, []byte(userInput.Password))
+	if !hasPassword || passwordErr != nil {
+		utils.RecordLoginFailure(userInput.Email)
 		utils.CreateError(iris.StatusUnauthorized, "Credentials Error", errorMsg, ctx)
 		return
 	}
 
-	returnUser(existingUser, ctx)
+	utils.ClearLoginFailures(userInput.Email)
+	loginOrChallenge(existingUser, ctx)
 }
 
 func FacebookLoginOrSignUp(ctx iris.Context) {
-	var userInput FacebookOrGoogleUserInput
+	var userInput FacebookUserInput
 	err := ctx.ReadJSON(&userInput)
 	if err != nil {
 		utils.HandleValidationErrors(err, ctx)
 		return
 	}
 
-	endpoint := "https://graph.facebook.com/me?fields=id,name,email&access_token=" + userInput.AccessToken
-	client := &http.Client{}
-	req, _ := http.NewRequest("GET", endpoint, nil)
-	res, facebookErr := client.Do(req)
-	if facebookErr != nil {
-		utils.CreateInternalServerError(ctx)
+	profile, err := fetchFacebookProfile(userInput.AccessToken)
+	if !handleSocialProfileError(err, ctx) {
 		return
 	}
 
-	defer res.Body.Close()
-	body, bodyErr := ioutil.ReadAll(res.Body)
-	if bodyErr != nil {
-		log.Panic(bodyErr)
+	socialLoginOrSignUp(profile, ctx)
+}
+
+// CreateLoginNonce issues the nonce a client needs to start a Google or
+// Apple sign-in.
+func CreateLoginNonce(ctx iris.Context) {
+	nonce, lifetime, err := utils.IssueLoginNonce()
+	if err != nil {
 		utils.CreateInternalServerError(ctx)
 		return
 	}
 
-	var facebookBody FacebookUserRes
-	json.Unmarshal(body, &facebookBody)
-
-	if facebookBody.Email != "" {
-		var user models.User
-		userExists, userExistsErr := getAndHandleUserExists(&user, facebookBody.Email)
-
-		if userExistsErr != nil {
-			utils.CreateInternalServerError(ctx)
-			return
-		}
-
-		if userExists == false {
-			nameArr := strings.SplitN(facebookBody.Name, " ", 2)
-			user = models.User{FirstName: nameArr[0], LastName: nameArr[1], Email: facebookBody.Email, SocialLogin: true, SocialProvider: "Facebook"}
-			storage.DB.Create(&user)
-
-			returnUser(user, ctx)
-			return
-		}
-
-		if user.SocialLogin == true && user.SocialProvider == "Facebook" {
-			returnUser(user, ctx)
-			return
-		}
-
-		utils.CreateEmailAlreadyRegistered(ctx)
-		return
-	}
+	ctx.JSON(iris.Map{
+		"nonce":     nonce,
+		"expiresIn": int(lifetime.Seconds()),
+	})
 }
 
 func GoogleLoginOrSignUp(ctx iris.Context) {
-	var userInput FacebookOrGoogleUserInput
+	var userInput GoogleUserInput
 	err := ctx.ReadJSON(&userInput)
 	if err != nil {
 		utils.HandleValidationErrors(err, ctx)
 		return
 	}
 
-	endpoint := "https://www.googleapis.com/userinfo/v2/me"
-
-	client := &http.Client{}
-	req, _ := http.NewRequest("GET", endpoint, nil)
-	header := "Bearer " + userInput.AccessToken
-	req.Header.Set("Authorization", header)
-	res, googleErr := client.Do(req)
-	if googleErr != nil {
-		utils.CreateInternalServerError(ctx)
+	profile, err := fetchGoogleProfile(userInput.IDToken, userInput.Nonce)
+	if !handleSocialProfileError(err, ctx) {
 		return
 	}
 
-	defer res.Body.Close()
-	body, bodyErr := ioutil.ReadAll(res.Body)
-	if bodyErr != nil {
-		log.Panic(bodyErr)
-		utils.CreateInternalServerError(ctx)
-		return
-	}
-
-	var googleBody GoogleUserRes
-	json.Unmarshal(body, &googleBody)
-
-	if googleBody.Email != "" {
-		var user models.User
-		userExists, userExistsErr := getAndHandleUserExists(&user, googleBody.Email)
-
-		if userExistsErr != nil {
-			utils.CreateInternalServerError(ctx)
-			return
-		}
-
-		if userExists == false {
-			user = models.User{FirstName: googleBody.GivenName, LastName: googleBody.FamilyName, Email: googleBody.Email, SocialLogin: true, SocialProvider: "Google"}
-			storage.DB.Create(&user)
-
-			returnUser(user, ctx)
-			return
-		}
-
-		if user.SocialLogin == true && user.SocialProvider == "Google" {
-			returnUser(user, ctx)
-			return
-		}
-
-		utils.CreateEmailAlreadyRegistered(ctx)
-		return
-
-	}
+	socialLoginOrSignUp(profile, ctx)
 }
 
 func AppleLoginOrSignUp(ctx iris.Context) {
@@ -221,60 +168,104 @@ func AppleLoginOrSignUp(ctx iris.Context) {
 		return
 	}
 
-	res, httpErr := http.Get("https://appleid.apple.com/auth/keys")
-	if httpErr != nil {
-		utils.CreateInternalServerError(ctx)
+	profile, err := fetchAppleProfile(userInput.IdentityToken, userInput.Nonce)
+	if !handleSocialProfileError(err, ctx) {
 		return
 	}
 
+	socialLoginOrSignUp(profile, ctx)
+}
+
+func fetchFacebookProfile(accessToken string) (*SocialProfile, error) {
+	endpoint := "https://graph.facebook.com/me?fields=id,name,email&access_token=" + url.QueryEscape(accessToken)
+	res, err := socialClient.Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
 	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
 
-	body, bodyErr := ioutil.ReadAll(res.Body)
-	if bodyErr != nil {
-		utils.CreateInternalServerError(ctx)
-		return
+	var facebookBody FacebookUserRes
+	json.Unmarshal(body, &facebookBody)
+
+	if facebookBody.ID == "" || facebookBody.Email == "" {
+		return nil, errInvalidSocialToken
 	}
 
-	jwks, jwksErr := keyfunc.NewJSON(body)
-	//The JWKS.Keyfunc method will automatically select the key with the matching kid (if present) and return its public key as the correct Go type to its caller.
-	token, tokenErr := jwt.Parse(userInput.IdentityToken, jwks.Keyfunc)
+	nameArr := append(strings.SplitN(facebookBody.Name, " ", 2), "")
 
-	if jwksErr != nil || tokenErr != nil {
-		utils.CreateInternalServerError(ctx)
-		return
+	// Facebook only returns confirmed email addresses.
+	return &SocialProfile{
+		Provider:      models.FacebookProvider,
+		Subject:       facebookBody.ID,
+		Email:         facebookBody.Email,
+		EmailVerified: true,
+		FirstName:     nameArr[0],
+		LastName:      nameArr[1],
+	}, nil
+}
+
+func fetchGoogleProfile(idToken string, nonce string) (*SocialProfile, error) {
+	return verifyIDTokenProfile(oidc.Google, models.GoogleProvider, idToken, nonce)
+}
+
+func fetchAppleProfile(identityToken string, nonce string) (*SocialProfile, error) {
+	return verifyIDTokenProfile(oidc.Apple, models.AppleProvider, identityToken, nonce)
+}
+
+// verifyIDTokenProfile checks the ID token and uses up its nonce, which
+// must be one CreateLoginNonce issued.
+func verifyIDTokenProfile(verifier *oidc.Verifier, provider string, idToken string, nonce string) (*SocialProfile, error) {
+	claims, err := verifier.Verify(idToken, nonce)
+	if errors.Is(err, oidc.ErrInvalidToken) || errors.Is(err, oidc.ErrNonceMismatch) {
+		return nil, errInvalidSocialToken
 	}
 
-	if !token.Valid {
-		utils.CreateError(iris.StatusUnauthorized, "Unauthorized", "Invalid user token.", ctx)
-		return
+	if err != nil {
+		return nil, err
 	}
 
-	email := fmt.Sprint(token.Claims.(jwt.MapClaims)["email"])
-	if email != "" {
-		var user models.User
-		userExists, userExistsErr := getAndHandleUserExists(&user, email)
+	consumed, err := utils.ConsumeLoginNonce(nonce)
+	if err != nil {
+		return nil, err
+	}
 
-		if userExistsErr != nil {
-			utils.CreateInternalServerError(ctx)
-			return
-		}
+	if !consumed {
+		return nil, errInvalidSocialToken
+	}
 
-		if userExists == false {
-			user = models.User{FirstName: "", LastName: "", Email: email, SocialLogin: true, SocialProvider: "Apple"}
-			storage.DB.Create(&user)
+	if claims.Email == "" {
+		return nil, errInvalidSocialToken
+	}
 
-			returnUser(user, ctx)
-			return
-		}
+	return &SocialProfile{
+		Provider:      provider,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		FirstName:     claims.GivenName,
+		LastName:      claims.FamilyName,
+	}, nil
+}
 
-		if user.SocialLogin == true && user.SocialProvider == "Apple" {
-			returnUser(user, ctx)
-			return
-		}
+// handleSocialProfileError writes the response for a failed provider lookup
+// and reports whether the handler may continue.
+func handleSocialProfileError(err error, ctx iris.Context) bool {
+	if errors.Is(err, errInvalidSocialToken) {
+		utils.CreateError(iris.StatusUnauthorized, "Unauthorized", "Invalid user token.", ctx)
+		return false
+	}
 
-		utils.CreateEmailAlreadyRegistered(ctx)
-		return
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return false
 	}
+
+	return true
 }
 
 func ForgotPassword(ctx iris.Context) {
@@ -293,51 +284,87 @@ func ForgotPassword(ctx iris.Context) {
 		return
 	}
 
-	if !userExists {
-		utils.CreateError(iris.StatusUnauthorized, "Credentials Error", "Invalid email.", ctx)
-		return
+	// The response is the same whether or not the email belongs to an
+	// account, and the email is sent in the background so the response time
+	// doesn't tell either. Accounts that signed up with a social login get
+	// the link too, so they can add a password.
+	if userExists {
+		go sendPasswordResetEmail(user)
 	}
 
-	if userExists {
-		if user.SocialLogin {
-			utils.CreateError(iris.StatusUnauthorized, "Credentials Error", "Social Login Account", ctx)
-			return
-		}
+	ctx.JSON(iris.Map{
+		"emailSent": true,
+	})
+}
 
-		link := "exp://192.168.30.24:19000/--/resetpassword/"
-		token, tokenErr := utils.CreateForgotPasswordToken(user.ID, user.Email)
+func sendPasswordResetEmail(user models.User) {
+	link := "exp://192.168.30.24:19000/--/resetpassword/"
+	token, err := utils.CreateForgotPasswordToken(user.ID, user.Email)
+	if err == nil {
+		err = email.Send(context.Background(), user.Email, user.Locale,
+			email.PasswordResetTemplate, email.PasswordResetData{Link: link + token})
+	}
 
-		if tokenErr != nil {
-			utils.CreateInternalServerError(ctx)
-			return
-		}
+	if err != nil {
+		log.Println("password reset email not sent to user", user.ID, err)
+	}
+}
 
-		link += token
-		subject := "Forgot Your Password?"
+func VerifyEmail(ctx iris.Context) {
+	claims := jsonWT.Get(ctx).(*utils.EmailVerificationToken)
 
-		html := `
-		<p>It looks like you forgot your password. 
-		If you did, please click the link below to reset it. 
-		If you did not, disregard this email. Please update your password
-		within 10 minutes, otherwise you will have to repeat this
-		process. <a href=` + link + `>Click to Reset Password</a>
-		</p><br />`
+	// The email must still match, so a link sent before an email change
+	// can't verify the new address.
+	emailVerified := storage.DB.Model(&models.User{}).
+		Where("id = ? AND email = ?", claims.ID, claims.Email).
+		Update("is_verified", true)
 
-		emailSent, emailSentErr := utils.SendMail(user.Email, subject, html)
-		if emailSentErr != nil {
-			utils.CreateInternalServerError(ctx)
-			return
-		}
+	if emailVerified.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
 
-		if emailSent {
-			ctx.JSON(iris.Map{
-				"emailSent": true,
-			})
-			return
-		}
+	if emailVerified.RowsAffected == 0 {
+		utils.CreateError(iris.StatusBadRequest, "Invalid Link", "This verification link is no longer valid.", ctx)
+		return
+	}
 
-		ctx.JSON(iris.Map{"emailSent": false})
+	ctx.JSON(iris.Map{
+		"verified": true,
+	})
+}
+
+func ResendVerificationEmail(ctx iris.Context) {
+	user := getCurrentUser(ctx)
+	if user == nil {
+		return
+	}
+
+	if user.IsVerified != nil && *user.IsVerified {
+		utils.CreateError(iris.StatusConflict, "Conflict", "Email already verified.", ctx)
+		return
 	}
+
+	if err := sendVerificationEmail(user); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.JSON(iris.Map{
+		"emailSent": true,
+	})
+}
+
+func sendVerificationEmail(user *models.User) error {
+	token, err := utils.CreateEmailVerificationToken(user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+
+	return email.Send(context.Background(), user.Email, user.Locale, email.EmailVerificationTemplate, email.EmailVerificationData{
+		FirstName: user.FirstName,
+		Link:      os.Getenv("EMAIL_VERIFICATION_URL") + "?token=" + token,
+	})
 }
 
 func ResetPassword(ctx iris.Context) {
@@ -356,8 +383,39 @@ func ResetPassword(ctx iris.Context) {
 
 	claims := jsonWT.Get(ctx).(*utils.ForgotPasswordToken)
 
-	var user models.User
-	storage.DB.Model(&user).Where("id = ?", claims.ID).Update("password", hashedPassword)
+	// Each link resets the password once.
+	unused, err := utils.UseForgotPasswordToken(jsonWT.GetVerifiedToken(ctx).StandardClaims.ID)
+	if err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if !unused {
+		utils.CreateError(iris.StatusUnauthorized, "Unauthorized", "This reset link has already been used.", ctx)
+		return
+	}
+
+	// The email must still match, so a link sent before an email change
+	// can't reset the account.
+	passwordUpdate := storage.DB.Model(&models.User{}).
+		Where("id = ? AND email = ?", claims.ID, claims.Email).
+		Update("password", hashedPassword)
+
+	if passwordUpdate.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	if passwordUpdate.RowsAffected == 0 {
+		utils.CreateError(iris.StatusUnauthorized, "Unauthorized", "This reset link is no longer valid.", ctx)
+		return
+	}
+
+	// Whoever knew the old password may still be signed in.
+	if err := utils.RevokeAllSessions(claims.ID); err != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
 
 	ctx.JSON(iris.Map{
 		"passwordReset": true,
@@ -429,6 +487,12 @@ func AlterUserSavedProperties(ctx iris.Context) {
 
 	if req.Op == "add" {
 		if !slices.Contains(unMarshalledProperties, req.PropertyID) {
+			maxSaved := user.MembershipTier.Entitlements().MaxSavedSearches
+			if !models.WithinLimit(int64(len(unMarshalledProperties)), maxSaved) {
+				utils.CreateUpgradeRequired("more saved properties", ctx)
+				return
+			}
+
 			savedProperties = append(unMarshalledProperties, req.PropertyID)
 		} else {
 			savedProperties = unMarshalledProperties
@@ -581,6 +645,32 @@ func AllowsNotifications(ctx iris.Context) {
 	ctx.StatusCode(iris.StatusNoContent)
 }
 
+func UpdateLocale(ctx iris.Context) {
+	params := ctx.Params()
+	id := params.Get("id")
+
+	user := getUserByID(id, ctx)
+	if user == nil {
+		return
+	}
+
+	var req UpdateLocaleInput
+	err := ctx.ReadJSON(&req)
+	if err != nil {
+		utils.HandleValidationErrors(err, ctx)
+		return
+	}
+
+	rowsUpdated := storage.DB.Model(user).Update("locale", email.SupportedLocale(req.Locale))
+
+	if rowsUpdated.Error != nil {
+		utils.CreateInternalServerError(ctx)
+		return
+	}
+
+	ctx.StatusCode(iris.StatusNoContent)
+}
+
 func getAndHandleUserExists(user *models.User, email string) (exists bool, err error) {
 	userExistsQuery := storage.DB.Where("email = ?", strings.ToLower(email)).Limit(1).Find(&user)
 
@@ -624,52 +714,40 @@ func getUserByID(id string, ctx iris.Context) *models.User {
 }
 
 func returnUser(user models.User, ctx iris.Context) {
-	tokenPair, tokenErr := utils.CreateTokenPair(user.ID)
-	if tokenErr != nil {
-		utils.CreateInternalServerError(ctx)
+	if user.SuspendedAt != nil {
+		utils.CreateAccountSuspended(ctx)
 		return
 	}
 
-	ctx.JSON(iris.Map{
-		"ID":                  user.ID,
-		"firstName":           user.FirstName,
-		"lastName":            user.LastName,
-		"email":               user.Email,
-		"savedProperties":     user.SavedProperties,
-		"allowsNotifications": user.AllowsNotifications,
-		"accessToken":         string(tokenPair.AccessToken),
-		"refreshToken":        string(tokenPair.RefreshToken),
-		"membershipTier":      user.MembershipTier,
-	})
-
-}
-
-func UpdateMembershipTier(ctx iris.Context) {
-	params := ctx.Params()
-	id := params.Get("id")
-
-	user := getUserByID(id, ctx)
-	if user == nil {
-		return
-	}
-
-	var req UpdateMembershipTierInput
-	err := ctx.ReadJSON(&req)
-	if err != nil {
-		utils.HandleValidationErrors(err, ctx)
+	profile, profileErr := buildProfile(user, false)
+	if profileErr != nil {
+		utils.CreateInternalServerError(ctx)
 		return
 	}
 
-	user.MembershipTier = req.MembershipTier
-
-	rowsUpdated := storage.DB.Model(&user).Updates(user)
-
-	if rowsUpdated.Error != nil {
+	tokenPair, tokenErr := utils.StartSession(&user, ctx)
+	if tokenErr != nil {
 		utils.CreateInternalServerError(ctx)
 		return
 	}
 
-	ctx.StatusCode(iris.StatusNoContent)
+	ctx.JSON(iris.Map{
+		"ID":                   user.ID,
+		"firstName":            user.FirstName,
+		"lastName":             user.LastName,
+		"email":                user.Email,
+		"savedProperties":      user.SavedProperties,
+		"allowsNotifications":  user.AllowsNotifications,
+		"accessToken":          string(tokenPair.AccessToken),
+		"refreshToken":         string(tokenPair.RefreshToken),
+		"membershipTier":       user.MembershipTier,
+		"role":                 user.Role,
+		"isVerified":           user.IsVerified != nil && *user.IsVerified,
+		"locale":               user.Locale,
+		"deletionScheduledFor": user.DeletionScheduledFor,
+		"profile":              profile,
+	})
+
 }
 
 type UpdateMembershipTierInput struct {
@@ -681,6 +759,7 @@ type RegisterUserInput struct {
 	LastName  string `json:"lastName" validate:"required,max=256"`
 	Email     string `json:"email" validate:"required,max=256,email"`
 	Password  string `json:"password" validate:"required,min=8,max=256"`
+	Locale    string `json:"locale" validate:"omitempty,max=35"`
 }
 
 type LoginUserInput struct {
@@ -688,12 +767,22 @@ type LoginUserInput struct {
 	Password string `json:"password" validate:"required"`
 }
 
-type FacebookOrGoogleUserInput struct {
+type FacebookUserInput struct {
 	AccessToken string `json:"accessToken" validate:"required"`
 }
 
+// GoogleUserInput carries the ID token from Google Sign-In and the nonce from
+// CreateLoginNonce the client sent with the sign-in request.
+type GoogleUserInput struct {
+	IDToken string `json:"idToken" validate:"required"`
+	Nonce   string `json:"nonce" validate:"required,max=256"`
+}
+
+// AppleUserInput carries the identity token from Sign in with Apple and the
+// nonce from CreateLoginNonce whose SHA-256 the client sent with the request.
 type AppleUserInput struct {
 	IdentityToken string `json:"identityToken" validate:"required"`
+	Nonce         string `json:"nonce" validate:"required,max=256"`
 }
 
 type FacebookUserRes struct {
@@ -702,18 +791,14 @@ type FacebookUserRes struct {
 	Email string `json:"email"`
 }
 
-type GoogleUserRes struct {
-	ID         string `json:"id"`
-	Email      string `json:"email"`
-	Name       string `json:"name"`
-	GivenName  string `json:"given_name"`
-	FamilyName string `json:"family_name"`
-}
-
 type EmailRegisteredInput struct {
 	Email string `json:"email" validate:"required"`
 }
 
+type UpdateLocaleInput struct {
+	Locale string `json:"locale" validate:"required,max=35"`
+}
+
 type ResetPasswordInput struct {
 	Password string `json:"password" validate:"required,min=8,max=256"`
 }
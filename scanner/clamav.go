@@ -0,0 +1,78 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamAVChunkSize is how much of the file is sent per INSTREAM chunk.
+const clamAVChunkSize = 64 << 10
+
+// ClamAV scans files with a clamd daemon over its INSTREAM protocol.
+type ClamAV struct {
+	addr    string
+	timeout time.Duration
+}
+
+func NewClamAV(addr string) *ClamAV {
+	return &ClamAV{addr: addr, timeout: 30 * time.Second}
+}
+
+func (c *ClamAV) Scan(ctx context.Context, data []byte) error {
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	// Each chunk is prefixed with its length; an empty chunk ends the stream.
+	var size [4]byte
+	for start := 0; start < len(data); start += clamAVChunkSize {
+		end := start + clamAVChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		binary.BigEndian.PutUint32(size[:], uint32(end-start))
+		if _, err := conn.Write(append(size[:], data[start:end]...)); err != nil {
+			return fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+	}
+
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := conn.Write(size[:]); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\x00')
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	// Replies look like "stream: OK" or "stream: Eicar-Signature FOUND".
+	reply = strings.TrimSuffix(reply, "\x00")
+	switch {
+	case strings.HasSuffix(reply, " OK"):
+		return nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return fmt.Errorf("%w: %s", ErrInfected, strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND"))
+	default:
+		return fmt.Errorf("%w: %s", ErrUnavailable, reply)
+	}
+}
@@ -0,0 +1,46 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+)
+
+var (
+	// ErrInfected is returned for files the scanner flags as malware.
+	ErrInfected = errors.New("file is infected")
+	// ErrUnavailable is returned when the file could not be scanned.
+	// Callers should reject the upload rather than store it unscanned.
+	ErrUnavailable = errors.New("virus scanner unavailable")
+)
+
+// Scanner checks uploaded files for malware before they are stored.
+type Scanner interface {
+	Scan(ctx context.Context, data []byte) error
+}
+
+// Default is the scanner used by the routes. It is set by Initialize.
+var Default Scanner
+
+func Initialize() {
+	switch os.Getenv("VIRUS_SCANNER") {
+	case "none":
+		log.Println("virus scanning is disabled; uploads are stored unscanned")
+		Default = NoopScanner{}
+	default:
+		addr := os.Getenv("CLAMAV_ADDR")
+		if addr == "" {
+			addr = "127.0.0.1:3310"
+		}
+
+		Default = NewClamAV(addr)
+	}
+}
+
+// NoopScanner accepts every file. It is meant for development only.
+type NoopScanner struct{}
+
+func (NoopScanner) Scan(ctx context.Context, data []byte) error {
+	return nil
+}
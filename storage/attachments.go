@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+)
+
+// UploadPrivateFile stores a file under the private delivery type, so it has
+// no public URL and can only be fetched through PrivateFileURL.
+// resourceType is "image" or "raw".
+func UploadPrivateFile(ctx context.Context, data []byte, folder string, resourceType string) (*uploader.UploadResult, error) {
+	result, err := Cld.Upload.Upload(ctx, bytes.NewReader(data), uploader.UploadParams{
+		Folder:       folder,
+		ResourceType: resourceType,
+		Type:         api.Private,
+	})
+	if err == nil && result.Error.Message != "" {
+		err = errors.New(result.Error.Message)
+	}
+
+	return result, err
+}
+
+// PrivateFileURL signs a download URL for a private file that stops working
+// at expiresAt.
+func PrivateFileURL(publicID string, format string, resourceType string, expiresAt time.Time) (string, error) {
+	return Cld.Upload.PrivateDownloadURL(uploader.PrivateDownloadURLParams{
+		PublicID:     publicID,
+		Format:       format,
+		DeliveryType: api.Private,
+		ExpiresAt:    &expiresAt,
+		ResourceType: api.AssetType(resourceType),
+	})
+}
+
+func DeletePrivateFile(ctx context.Context, publicID string, resourceType string) error {
+	_, err := Cld.Upload.Destroy(ctx, uploader.DestroyParams{
+		PublicID:     publicID,
+		Type:         api.Private,
+		ResourceType: resourceType,
+	})
+	return err
+}
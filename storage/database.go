@@ -89,6 +89,11 @@ func connectToDB() *gorm.DB {
 }
 
 func performMigrations(db *gorm.DB) {
+	// Run data migrations first so AutoMigrate sees the converted columns
+	if err := runMigrations(db); err != nil {
+		log.Panic("Error running migrations: ", err)
+	}
+
 	// Perform database migrations
 	db.AutoMigrate(
 		&models.Conversation{},
@@ -97,6 +102,18 @@ func performMigrations(db *gorm.DB) {
 		&models.Property{},
 		&models.Review{},
 		&models.Apartment{},
+		&models.Reservation{},
+		&models.ModerationItem{},
+		&models.AuditLog{},
+		&models.Subscription{},
+		&models.Promotion{},
+		&models.RecoveryCode{},
+		&models.Identity{},
+		&models.DataExport{},
+		&models.ReviewRevision{},
+		&models.ReviewReport{},
+		&models.ReviewHelpfulVote{},
+		&models.MessageAttachment{},
 	)
 }
 
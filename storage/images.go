@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+)
+
+var ErrNotStoredImage = errors.New("not an image in our Cloudinary account")
+
+// DeleteImage removes an uploaded image given the secure URL it was stored
+// under, e.g. https://res.cloudinary.com/<cloud>/image/upload/v123/property/1/abc/xyz.jpg.
+func DeleteImage(ctx context.Context, imageURL string) error {
+	publicID, err := imagePublicID(imageURL)
+	if err != nil {
+		return err
+	}
+
+	_, err = Cld.Upload.Destroy(ctx, uploader.DestroyParams{PublicID: publicID})
+	return err
+}
+
+// imagePublicID extracts the public ID: the path after the upload type and
+// optional version, without the file extension.
+func imagePublicID(imageURL string) (string, error) {
+	parsed, err := url.Parse(imageURL)
+	if err != nil || parsed.Host != "res.cloudinary.com" {
+		return "", ErrNotStoredImage
+	}
+
+	_, publicPath, found := strings.Cut(parsed.Path, "/upload/")
+	if !found {
+		return "", ErrNotStoredImage
+	}
+
+	if version, rest, found := strings.Cut(publicPath, "/"); found && len(version) > 1 && version[0] == 'v' && strings.Trim(version[1:], "0123456789") == "" {
+		publicPath = rest
+	}
+
+	return strings.TrimSuffix(publicPath, path.Ext(publicPath)), nil
+}
@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"habitat-server/models"
+	"habitat-server/ratings"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SchemaMigration records a data migration that has been applied.
+type SchemaMigration struct {
+	ID        string `gorm:"primaryKey"`
+	AppliedAt time.Time
+}
+
+type migration struct {
+	ID      string
+	Migrate func(tx *gorm.DB) error
+}
+
+// migrations run once each, in order, before AutoMigrate. They cover changes
+// AutoMigrate cannot make safely, such as converting existing values.
+var migrations = []migration{
+	{ID: "0001_international_addresses_and_money", Migrate: migrateInternationalAddressesAndMoney},
+	{ID: "0002_listing_status", Migrate: migrateListingStatus},
+	{ID: "0003_user_roles", Migrate: migrateUserRoles},
+	{ID: "0004_append_only_audit_log", Migrate: migrateAppendOnlyAuditLog},
+	{ID: "0005_grandfather_email_verification", Migrate: migrateGrandfatherEmailVerification},
+	{ID: "0006_social_identities", Migrate: migrateSocialIdentities},
+	{ID: "0007_one_review_per_property", Migrate: migrateOneReviewPerProperty},
+	{ID: "0008_property_rating_aggregates", Migrate: migratePropertyRatingAggregates},
+	{ID: "0009_conversation_read_markers", Migrate: migrateConversationReadMarkers},
+}
+
+func runMigrations(db *gorm.DB) error {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		var applied int64
+		if err := db.Model(&SchemaMigration{}).Where("id = ?", m.ID).Count(&applied).Error; err != nil {
+			return err
+		}
+
+		if applied > 0 {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Migrate(tx); err != nil {
+				return err
+			}
+
+			return tx.Create(&SchemaMigration{ID: m.ID, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Println("applied migration", m.ID)
+	}
+
+	return nil
+}
+
+// columnType returns the Postgres data type of a column, or "" when the
+// table or column does not exist yet.
+func columnType(tx *gorm.DB, table string, column string) (string, error) {
+	var dataType string
+	err := tx.Raw(
+		"SELECT data_type FROM information_schema.columns WHERE table_name = ? AND column_name = ?",
+		table, column).Scan(&dataType).Error
+
+	return dataType, err
+}
+
+// migrateInternationalAddressesAndMoney turns zip codes into text, keeping
+// the leading zeros the integer column dropped, and converts dollar amounts
+// stored as floats into integer cents. Every existing row is a US listing.
+func migrateInternationalAddressesAndMoney(tx *gorm.DB) error {
+	zipType, err := columnType(tx, "properties", "zip")
+	if err != nil {
+		return err
+	}
+
+	if zipType == "integer" || zipType == "bigint" {
+		err := tx.Exec("ALTER TABLE properties ALTER COLUMN zip TYPE text USING lpad(zip::text, 5, '0')").Error
+		if err != nil {
+			return err
+		}
+	}
+
+	amounts := map[string][]string{
+		"properties":   {"rent_low", "rent_high", "parking_fee"},
+		"apartments":   {"rent", "deposit"},
+		"reservations": {"total_price"},
+	}
+
+	for table, columns := range amounts {
+		for _, column := range columns {
+			dataType, err := columnType(tx, table, column)
+			if err != nil {
+				return err
+			}
+
+			if dataType != "real" && dataType != "double precision" && dataType != "numeric" {
+				continue
+			}
+
+			err = tx.Exec("ALTER TABLE " + table + " ALTER COLUMN " + column +
+				" TYPE bigint USING round(" + column + " * 100)").Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrateListingStatus adds the moderation status to properties. Listings
+// that predate moderation are approved so they stay visible in search.
+func migrateListingStatus(tx *gorm.DB) error {
+	propertiesType, err := columnType(tx, "properties", "id")
+	if err != nil || propertiesType == "" {
+		return err
+	}
+
+	statusType, err := columnType(tx, "properties", "status")
+	if err != nil || statusType != "" {
+		return err
+	}
+
+	err = tx.Exec("ALTER TABLE properties ADD COLUMN status text DEFAULT 'pending_review'").Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Exec("UPDATE properties SET status = 'approved'").Error
+}
+
+// migrateUserRoles gives every existing user a role: staff flagged users
+// become staff, anyone with a listing becomes an owner.
+func migrateUserRoles(tx *gorm.DB) error {
+	usersType, err := columnType(tx, "users", "id")
+	if err != nil || usersType == "" {
+		return err
+	}
+
+	roleType, err := columnType(tx, "users", "role")
+	if err != nil || roleType != "" {
+		return err
+	}
+
+	if err := tx.Exec("ALTER TABLE users ADD COLUMN role text DEFAULT 'tenant'").Error; err != nil {
+		return err
+	}
+
+	err = tx.Exec("UPDATE users SET role = 'owner' WHERE id IN (SELECT user_id FROM properties WHERE deleted_at IS NULL)").Error
+	if err != nil {
+		return err
+	}
+
+	staffType, err := columnType(tx, "users", "is_staff")
+	if err != nil || staffType == "" {
+		return err
+	}
+
+	if err := tx.Exec("UPDATE users SET role = 'staff' WHERE is_staff").Error; err != nil {
+		return err
+	}
+
+	return tx.Exec("ALTER TABLE users DROP COLUMN is_staff").Error
+}
+
+// migrateAppendOnlyAuditLog creates the audit log with a trigger that
+// rejects any UPDATE or DELETE, so entries can only ever be added.
+func migrateAppendOnlyAuditLog(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&models.AuditLog{}); err != nil {
+		return err
+	}
+
+	err := tx.Exec(`
+		CREATE OR REPLACE FUNCTION audit_logs_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_logs is append-only';
+		END;
+		$$ LANGUAGE plpgsql`).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Exec(`
+		CREATE TRIGGER audit_logs_append_only
+		BEFORE UPDATE OR DELETE ON audit_logs
+		FOR EACH ROW EXECUTE FUNCTION audit_logs_append_only()`).Error
+}
+
+// migrateGrandfatherEmailVerification marks accounts created before email
+// verification existed as verified, so the new restrictions don't lock
+// existing users out.
+func migrateGrandfatherEmailVerification(tx *gorm.DB) error {
+	verifiedType, err := columnType(tx, "users", "is_verified")
+	if err != nil || verifiedType == "" {
+		return err
+	}
+
+	return tx.Exec("UPDATE users SET is_verified = true WHERE is_verified IS NULL").Error
+}
+
+// migrateSocialIdentities gives every social account an identity for its
+// provider. The provider's user ID was never stored, so the identity is keyed
+// on the email until the user's next social login fills in the real one.
+func migrateSocialIdentities(tx *gorm.DB) error {
+	providerType, err := columnType(tx, "users", "social_provider")
+	if err != nil || providerType == "" {
+		return err
+	}
+
+	if err := tx.AutoMigrate(&models.Identity{}); err != nil {
+		return err
+	}
+
+	return tx.Exec(`INSERT INTO identities (created_at, updated_at, user_id, provider, subject, email)
+		SELECT NOW(), NOW(), id, social_provider, 'legacy:' || LOWER(email), LOWER(email)
+		FROM users
+		WHERE social_login = true AND social_provider <> '' AND deleted_at IS NULL`).Error
+}
+
+// migrateOneReviewPerProperty keeps only each user's latest review of a
+// property so the unique index on author and property can be created. The
+// older ones are soft deleted rather than lost.
+func migrateOneReviewPerProperty(tx *gorm.DB) error {
+	userIDType, err := columnType(tx, "reviews", "user_id")
+	if err != nil || userIDType == "" {
+		return err
+	}
+
+	return tx.Exec(`UPDATE reviews SET deleted_at = NOW()
+		WHERE deleted_at IS NULL AND id NOT IN (
+			SELECT MAX(id) FROM reviews WHERE deleted_at IS NULL GROUP BY user_id, property_id
+		)`).Error
+}
+
+// migratePropertyRatingAggregates fills in the review count and star sum of
+// existing properties from their published reviews.
+func migratePropertyRatingAggregates(tx *gorm.DB) error {
+	propertyIDType, err := columnType(tx, "properties", "id")
+	if err != nil || propertyIDType == "" {
+		return err
+	}
+
+	if err := tx.AutoMigrate(&models.Property{}, &models.Review{}); err != nil {
+		return err
+	}
+
+	_, err = ratings.RecomputeAll(tx)
+	return err
+}
+
+// migrateConversationReadMarkers marks existing conversations as read by
+// both participants, so history sent before read receipts existed doesn't
+// all show up as unread.
+func migrateConversationReadMarkers(tx *gorm.DB) error {
+	messageIDType, err := columnType(tx, "messages", "id")
+	if err != nil || messageIDType == "" {
+		return err
+	}
+
+	if err := tx.AutoMigrate(&models.Conversation{}); err != nil {
+		return err
+	}
+
+	return tx.Exec(`UPDATE conversations SET
+			tenant_last_read_message_id = latest.message_id,
+			owner_last_read_message_id = latest.message_id
+		FROM (SELECT conversation_id, MAX(id) AS message_id FROM messages GROUP BY conversation_id) latest
+		WHERE conversations.id = latest.conversation_id`).Error
+}
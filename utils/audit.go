@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"encoding/json"
+	"habitat-server/models"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"gorm.io/gorm"
+)
+
+// RecordAudit appends an entry for an action taken by the authenticated
+// user to the audit log. Pass the transaction the action ran in so the two
+// are committed together.
+func RecordAudit(tx *gorm.DB, ctx iris.Context, action string, targetType string, targetID uint, details iris.Map) error {
+	claims := jwt.Get(ctx).(*AccessToken)
+
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	return tx.Create(&models.AuditLog{
+		ActorID:    claims.ID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    detailsJSON,
+		IP:         ctx.RemoteAddr(),
+	}).Error
+}
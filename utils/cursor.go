@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/kataras/iris/v12"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks where a page of results ended: the sort key of the last row
+// and its ID to break ties. Clients get it as an opaque string.
+type Cursor struct {
+	Key int64 `json:"k"`
+	ID  uint  `json:"id"`
+}
+
+func EncodeCursor(key int64, id uint) string {
+	data, _ := json.Marshal(Cursor{Key: key, ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor from a request. An empty string is the
+// first page and returns nil.
+func DecodeCursor(cursor string) (*Cursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var decoded Cursor
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.ID == 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &decoded, nil
+}
+
+// PageLimit reads the limit query parameter, falling back to defaultLimit
+// when it is missing or outside 1..maxLimit.
+func PageLimit(ctx iris.Context, defaultLimit int, maxLimit int) int {
+	limit := ctx.URLParamIntDefault("limit", defaultLimit)
+	if limit < 1 || limit > maxLimit {
+		return defaultLimit
+	}
+
+	return limit
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"habitat-server/models"
+	"habitat-server/storage"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+)
+
+// UserEntitlements loads the entitlements of the user's current tier. The
+// tier is read from the database rather than the access token so upgrades
+// apply immediately.
+func UserEntitlements(userID uint) (models.Entitlements, error) {
+	var user models.User
+	err := storage.DB.Select("id", "membership_tier").First(&user, userID).Error
+	if err != nil {
+		return models.Entitlements{}, err
+	}
+
+	return user.MembershipTier.Entitlements(), nil
+}
+
+// RequireEntitlement only lets a request through when the user's tier
+// passes allowed. It must run after the access token verifier.
+func RequireEntitlement(feature string, allowed func(models.Entitlements) bool) iris.Handler {
+	return func(ctx iris.Context) {
+		claims := jwt.Get(ctx).(*AccessToken)
+
+		entitlements, err := UserEntitlements(claims.ID)
+		if err != nil {
+			CreateInternalServerError(ctx)
+			return
+		}
+
+		if !allowed(entitlements) {
+			CreateUpgradeRequired(feature, ctx)
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// CountActiveListings counts the listings that use up a tier's allowance:
+// those in review or approved and not taken off the market.
+func CountActiveListings(userID uint) (int64, error) {
+	var count int64
+	err := storage.DB.Model(&models.Property{}).
+		Where("user_id = ? AND status IN ? AND (on_market IS NULL OR on_market = true)",
+			userID, []models.ListingStatus{models.ListingPendingReview, models.ListingApproved}).
+		Count(&count).Error
+
+	return count, err
+}
+
+// IsActiveListing reports whether the listing is one CountActiveListings
+// counts.
+func IsActiveListing(property *models.Property) bool {
+	return (property.Status == models.ListingPendingReview || property.Status == models.ListingApproved) &&
+		(property.OnMarket == nil || *property.OnMarket)
+}
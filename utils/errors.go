@@ -2,6 +2,8 @@ package utils
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/kataras/iris/v12"
@@ -28,6 +30,58 @@ func CreateEmailAlreadyRegistered(ctx iris.Context) {
 	)
 }
 
+func CreateAccountSuspended(ctx iris.Context) {
+	CreateError(
+		iris.StatusForbidden,
+		"Forbidden",
+		"Account suspended.",
+		ctx,
+	)
+}
+
+func CreateEmailNotVerified(ctx iris.Context) {
+	CreateError(
+		iris.StatusForbidden,
+		"Email Not Verified",
+		"Verify your email address to continue.",
+		ctx,
+	)
+}
+
+func CreateTwoFactorRequired(ctx iris.Context) {
+	CreateError(
+		iris.StatusForbidden,
+		"Two-Factor Required",
+		"Enable two-factor authentication and sign in again to continue.",
+		ctx,
+	)
+}
+
+func CreateUpgradeRequired(feature string, ctx iris.Context) {
+	ctx.StopWithProblem(iris.StatusPaymentRequired, iris.NewProblem().
+		Title("Upgrade Required").
+		Detail("Your membership tier does not include "+feature+".").
+		Key("feature", feature))
+}
+
+// CreateAccountLinkRequired answers a social login whose email belongs to an
+// existing account. The owner signs in to that account and presents the link
+// token to attach the social identity.
+func CreateAccountLinkRequired(linkToken string, ctx iris.Context) {
+	ctx.StopWithProblem(iris.StatusConflict, iris.NewProblem().
+		Title("Account Exists").
+		Detail("Sign in to the existing account to link this login to it.").
+		Key("linkToken", linkToken))
+}
+
+// CreateTooManyRequests tells the client how long to wait, rounded up to
+// whole seconds, in a Retry-After header.
+func CreateTooManyRequests(retryAfter time.Duration, ctx iris.Context) {
+	seconds := int64((retryAfter + time.Second - 1) / time.Second)
+	ctx.Header("Retry-After", strconv.FormatInt(seconds, 10))
+	CreateError(iris.StatusTooManyRequests, "Too Many Requests", "Too many attempts. Try again later.", ctx)
+}
+
 func CreateNotFound(ctx iris.Context) {
 	ctx.StatusCode(iris.StatusNotFound)
 	ctx.Text("Not Found")
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"habitat-server/storage"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLockoutThreshold = 5
+	// loginFailureMemory is how long failed attempts count towards a
+	// lockout after the last one.
+	loginFailureMemory = 24 * time.Hour
+	baseLockout        = time.Minute
+	maxLockout         = 24 * time.Hour
+)
+
+// LoginLockout returns how long the account is locked out for, or zero.
+// Accounts are keyed by email whether or not it is registered, so a lockout
+// says nothing about which emails exist.
+func LoginLockout(email string) time.Duration {
+	ttl, err := storage.Redis.PTTL(bgContext, loginLockKey(email)).Result()
+	if err != nil || ttl <= 0 {
+		return 0
+	}
+
+	return ttl
+}
+
+// RecordLoginFailure counts a failed login. Once the failures reach
+// LOGIN_LOCKOUT_THRESHOLD (default 5) the account is locked, for a minute at
+// first and twice as long with every further failure, up to a day.
+func RecordLoginFailure(email string) {
+	failuresKey := loginFailuresKey(email)
+
+	failures, err := storage.Redis.Incr(bgContext, failuresKey).Result()
+	if err != nil {
+		return
+	}
+	storage.Redis.Expire(bgContext, failuresKey, loginFailureMemory)
+
+	threshold := int64(defaultLockoutThreshold)
+	if configured, err := strconv.ParseInt(os.Getenv("LOGIN_LOCKOUT_THRESHOLD"), 10, 64); err == nil && configured > 0 {
+		threshold = configured
+	}
+
+	if failures < threshold {
+		return
+	}
+
+	lockout := maxLockout
+	if excess := failures - threshold; excess < 11 {
+		lockout = baseLockout << excess
+		if lockout > maxLockout {
+			lockout = maxLockout
+		}
+	}
+
+	storage.Redis.Set(bgContext, loginLockKey(email), "true", lockout)
+}
+
+func ClearLoginFailures(email string) {
+	storage.Redis.Del(bgContext, loginFailuresKey(email), loginLockKey(email))
+}
+
+func loginFailuresKey(email string) string {
+	return "login_failures:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func loginLockKey(email string) string {
+	return "login_lock:" + strings.ToLower(strings.TrimSpace(email))
+}
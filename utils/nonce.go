@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"habitat-server/storage"
+	"time"
+
+	"github.com/thanhpk/randstr"
+)
+
+// loginNonceLifetime is how long a client has to finish a Google or Apple
+// sign-in with a nonce it was issued.
+const loginNonceLifetime = 10 * time.Minute
+
+// IssueLoginNonce creates a nonce for a Google or Apple sign-in. The client
+// puts it in the provider's sign-in request, or its SHA-256 for Apple, and
+// sends it back with the ID token, where ConsumeLoginNonce accepts it once.
+func IssueLoginNonce() (string, time.Duration, error) {
+	nonce := randstr.Hex(16)
+	if err := storage.Redis.Set(bgContext, loginNonceKey(nonce), "1", loginNonceLifetime).Err(); err != nil {
+		return "", 0, err
+	}
+
+	return nonce, loginNonceLifetime, nil
+}
+
+// ConsumeLoginNonce reports whether the nonce was issued and not yet used,
+// and uses it up, so an ID token can't be replayed.
+func ConsumeLoginNonce(nonce string) (bool, error) {
+	if nonce == "" {
+		return false, nil
+	}
+
+	deleted, err := storage.Redis.Del(bgContext, loginNonceKey(nonce)).Result()
+	return deleted == 1, err
+}
+
+func loginNonceKey(nonce string) string {
+	return "login_nonce:" + nonce
+}
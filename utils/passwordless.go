@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"habitat-server/storage"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/thanhpk/randstr"
+)
+
+const (
+	loginCodeLifetime = 10 * time.Minute
+	// maxLoginCodeAttempts is how many wrong codes or links are accepted
+	// before the pending login is thrown away and a new one must be
+	// requested.
+	maxLoginCodeAttempts = 5
+)
+
+// checkLoginCodeScript consumes the pending login if the presented code or
+// link token matches. Misses are counted, and the pending login is deleted
+// once they reach the limit. It returns 1 on a match, otherwise 0.
+var checkLoginCodeScript = redis.NewScript(`
+local stored = redis.call('HGET', KEYS[1], ARGV[1])
+if not stored then
+	return 0
+end
+if stored == ARGV[2] then
+	redis.call('DEL', KEYS[1])
+	return 1
+end
+if redis.call('HINCRBY', KEYS[1], 'attempts', 1) >= tonumber(ARGV[3]) then
+	redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// CreateLoginCode starts a passwordless login for email, replacing any
+// pending one. It returns a six digit code to type in and a token for a
+// magic link; either completes the login once. Only their hashes are
+// stored.
+func CreateLoginCode(email string) (code string, token string, err error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", "", err
+	}
+
+	code = fmt.Sprintf("%06d", n.Int64())
+	token = randstr.Hex(32)
+
+	key := loginCodeKey(email)
+	_, err = storage.Redis.TxPipelined(bgContext, func(pipe redis.Pipeliner) error {
+		pipe.Del(bgContext, key)
+		pipe.HSet(bgContext, key,
+			"code", hashLoginSecret(code),
+			"token", hashLoginSecret(token),
+			"attempts", 0,
+		)
+		pipe.Expire(bgContext, key, loginCodeLifetime)
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return code, token, nil
+}
+
+// CheckLoginCode reports whether code, or token when code is empty, matches
+// the pending login for email, consuming it if so.
+func CheckLoginCode(email string, code string, token string) (bool, error) {
+	field, secret := "code", code
+	if code == "" {
+		field, secret = "token", token
+	}
+
+	if secret == "" {
+		return false, nil
+	}
+
+	matched, err := checkLoginCodeScript.Run(bgContext, storage.Redis, []string{loginCodeKey(email)},
+		field, hashLoginSecret(secret), maxLoginCodeAttempts).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return matched == 1, nil
+}
+
+func hashLoginSecret(secret string) string {
+	hash := sha256.Sum256([]byte(strings.TrimSpace(secret)))
+	return hex.EncodeToString(hash[:])
+}
+
+func loginCodeKey(email string) string {
+	return "login_code:" + strings.ToLower(strings.TrimSpace(email))
+}
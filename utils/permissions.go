@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"habitat-server/models"
+	"habitat-server/storage"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+)
+
+// Can reports whether the token grants the permission.
+func (c *AccessToken) Can(permission models.Permission) bool {
+	for _, granted := range c.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequirePermission only lets requests through whose access token grants
+// every one of the permissions. Users who must use two-factor also need a
+// token issued after a second factor. It must run after the access token
+// verifier.
+func RequirePermission(permissions ...models.Permission) iris.Handler {
+	return func(ctx iris.Context) {
+		claims := jwt.Get(ctx).(*AccessToken)
+
+		if TwoFactorMandatory(claims.Role, claims.TwoFactorRequired) && !claims.MFA {
+			CreateTwoFactorRequired(ctx)
+			return
+		}
+
+		for _, permission := range permissions {
+			if !claims.Can(permission) {
+				ctx.StatusCode(iris.StatusForbidden)
+				return
+			}
+		}
+
+		ctx.Next()
+	}
+}
+
+// CurrentUserMiddleware exposes the authenticated user's ID as the "userID"
+// context value.
+func CurrentUserMiddleware(ctx iris.Context) {
+	claims := jwt.Get(ctx).(*AccessToken)
+
+	ctx.Values().Set("userID", claims.ID)
+	ctx.Next()
+}
+
+// PromoteToOwner gives a tenant the owner role once they list a property.
+func PromoteToOwner(userID uint) error {
+	return storage.DB.Model(&models.User{}).
+		Where("id = ? AND role = ?", userID, models.TenantRole).
+		Update("role", models.OwnerRole).Error
+}
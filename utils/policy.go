@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"habitat-server/models"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+)
+
+// The policy functions decide access to a single resource from the caller's
+// access token. Handlers load the resource, then call Authorize with the
+// matching policy.
+
+func CanViewProperty(claims *AccessToken, property *models.Property) bool {
+	if property.Status == models.ListingApproved {
+		return true
+	}
+
+	if claims == nil {
+		return false
+	}
+
+	return property.UserID == claims.ID || claims.Can(models.PermModerateListings)
+}
+
+func CanManageProperty(claims *AccessToken, property *models.Property) bool {
+	if claims.Can(models.PermManageAnyProperty) {
+		return true
+	}
+
+	return property.UserID == claims.ID && claims.Can(models.PermManageOwnProperties)
+}
+
+// CanViewReservation lets the guest, the owner of the reserved property and
+// staff see a reservation.
+func CanViewReservation(claims *AccessToken, reservation *models.Reservation, property *models.Property) bool {
+	if reservation.UserID == claims.ID || claims.Can(models.PermViewAnyReservation) {
+		return true
+	}
+
+	return property != nil && property.UserID == claims.ID
+}
+
+func CanManageReservation(claims *AccessToken, reservation *models.Reservation) bool {
+	return reservation.UserID == claims.ID || claims.Can(models.PermManageAnyReservation)
+}
+
+func CanAccessConversation(claims *AccessToken, tenantID uint, ownerID uint) bool {
+	return claims.ID == tenantID || claims.ID == ownerID
+}
+
+// CanViewReviewHistory lets a review's author and review moderators see
+// earlier versions of the review.
+func CanViewReviewHistory(claims *AccessToken, review *models.Review) bool {
+	return review.UserID == claims.ID || claims.Can(models.PermModerateReviews)
+}
+
+// Claims returns the verified access token claims, or nil for anonymous
+// requests.
+func Claims(ctx iris.Context) *AccessToken {
+	claims, _ := jwt.Get(ctx).(*AccessToken)
+	return claims
+}
+
+// Authorize writes a forbidden response when allowed is false and reports
+// whether the handler may continue.
+func Authorize(allowed bool, ctx iris.Context) bool {
+	if !allowed {
+		ctx.StatusCode(iris.StatusForbidden)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"habitat-server/storage"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"github.com/thanhpk/randstr"
+)
+
+// slidingWindowScript keeps the timestamps of recent requests in a sorted
+// set, drops those older than the window and admits the request if fewer
+// than the limit remain. It returns 0 when admitted, otherwise the
+// milliseconds until the oldest request leaves the window.
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+if redis.call('ZCARD', KEYS[1]) >= limit then
+	local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+	return math.max(tonumber(oldest[2]) + window - now, 1)
+end
+redis.call('ZADD', KEYS[1], now, ARGV[4])
+redis.call('PEXPIRE', KEYS[1], window)
+return 0
+`)
+
+// RateLimit allows at most limit requests per sliding window for every key
+// returned by keyFunc. The limit can be overridden without a deploy with
+// RATE_LIMIT_<NAME>, e.g. RATE_LIMIT_LOGIN_IP=20/15m. Requests are let
+// through if Redis is unavailable.
+func RateLimit(name string, limit int64, window time.Duration, keyFunc func(ctx iris.Context) string) iris.Handler {
+	limit, window = rateLimitConfig(name, limit, window)
+
+	return func(ctx iris.Context) {
+		key := "ratelimit:" + name + ":" + keyFunc(ctx)
+		now := time.Now().UnixMilli()
+
+		wait, err := slidingWindowScript.Run(bgContext, storage.Redis, []string{key},
+			now, window.Milliseconds(), limit, strconv.FormatInt(now, 10)+"-"+randstr.Hex(4)).Int64()
+		if err != nil {
+			ctx.Next()
+			return
+		}
+
+		if wait > 0 {
+			CreateTooManyRequests(time.Duration(wait)*time.Millisecond, ctx)
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// rateLimitConfig applies a "limit/window" override from the environment.
+func rateLimitConfig(name string, limit int64, window time.Duration) (int64, time.Duration) {
+	envName := "RATE_LIMIT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	configured := os.Getenv(envName)
+	if configured == "" {
+		return limit, window
+	}
+
+	limitStr, windowStr, _ := strings.Cut(configured, "/")
+	configuredLimit, limitErr := strconv.ParseInt(limitStr, 10, 64)
+	configuredWindow, windowErr := time.ParseDuration(windowStr)
+	if limitErr != nil || windowErr != nil || configuredLimit < 1 || configuredWindow <= 0 {
+		log.Println("ignoring invalid", envName, configured)
+		return limit, window
+	}
+
+	return configuredLimit, configuredWindow
+}
+
+// UserOrIPKey keys a rate limit on the authenticated user, falling back to
+// the client IP for anonymous requests.
+func UserOrIPKey(ctx iris.Context) string {
+	if claims, ok := jwt.Get(ctx).(*AccessToken); ok && claims != nil {
+		return "user:" + strconv.FormatUint(uint64(claims.ID), 10)
+	}
+
+	return IPKey(ctx)
+}
+
+func IPKey(ctx iris.Context) string {
+	return "ip:" + ctx.RemoteAddr()
+}
+
+// AccountKey keys a rate limit on the email in the JSON body, so attempts
+// against one account are limited however many IPs they come from. The
+// body is restored for the handler.
+func AccountKey(ctx iris.Context) string {
+	body, err := io.ReadAll(ctx.Request().Body)
+	ctx.Request().Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return IPKey(ctx)
+	}
+
+	var account struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(body, &account) != nil || account.Email == "" {
+		return IPKey(ctx)
+	}
+
+	return "account:" + strings.ToLower(strings.TrimSpace(account.Email))
+}
@@ -0,0 +1,323 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"habitat-server/models"
+	"habitat-server/storage"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+	"github.com/thanhpk/randstr"
+)
+
+var (
+	ErrSessionRevoked     = errors.New("session revoked")
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// Session is a signed-in device. It is stored in Redis as a hash at
+// session:<id>, and the user's session IDs are kept in user_sessions:<id>.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     uint      `json:"-"`
+	DeviceName string    `json:"deviceName"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	Current    bool      `json:"current"`
+}
+
+// rotateRefreshTokenScript swaps the session's refresh token ID if the
+// presented one is current. It returns 1 on success, -1 when the presented
+// token was already rotated and 0 when the session is gone.
+var rotateRefreshTokenScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], 'refresh_jti')
+if not current then
+	return 0
+end
+if current ~= ARGV[1] then
+	return -1
+end
+redis.call('HSET', KEYS[1], 'refresh_jti', ARGV[2], 'last_seen_at', ARGV[3], 'ip', ARGV[4], 'user_agent', ARGV[5])
+redis.call('EXPIRE', KEYS[1], ARGV[6])
+return 1
+`)
+
+// touchSessionScript records activity on a session that still exists and
+// reports whether it does.
+var touchSessionScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'last_seen_at', ARGV[1])
+return 1
+`)
+
+// StartSession records a new session for the device making the request and
+// issues its first token pair.
+func StartSession(user *models.User, ctx iris.Context) (*jwt.TokenPair, error) {
+	sessionID := randstr.Hex(16)
+	refreshTokenID := randstr.Hex(16)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	userAgent := ctx.GetHeader("User-Agent")
+	deviceName := ctx.GetHeader("X-Device-Name")
+	if deviceName == "" {
+		deviceName = userAgent
+	}
+
+	key := sessionKey(sessionID)
+	_, err := storage.Redis.TxPipelined(bgContext, func(pipe redis.Pipeliner) error {
+		pipe.HSet(bgContext, key,
+			"user_id", user.ID,
+			"device_name", deviceName,
+			"user_agent", userAgent,
+			"ip", ctx.RemoteAddr(),
+			"created_at", now,
+			"last_seen_at", now,
+			"refresh_jti", refreshTokenID,
+		)
+		pipe.Expire(bgContext, key, refreshTokenLifetime)
+		pipe.SAdd(bgContext, userSessionsKey(user.ID), sessionID)
+		pipe.Expire(bgContext, userSessionsKey(user.ID), refreshTokenLifetime)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return createTokenPair(user, sessionID, refreshTokenID)
+}
+
+// rotateSession issues a new pair for an existing session if refreshTokenID
+// is the session's current refresh token. A stale one revokes the session.
+func rotateSession(user *models.User, sessionID string, refreshTokenID string, ctx iris.Context) (*jwt.TokenPair, error) {
+	nextRefreshTokenID := randstr.Hex(16)
+
+	result, err := rotateRefreshTokenScript.Run(bgContext, storage.Redis, []string{sessionKey(sessionID)},
+		refreshTokenID,
+		nextRefreshTokenID,
+		time.Now().Unix(),
+		ctx.RemoteAddr(),
+		ctx.GetHeader("User-Agent"),
+		int64(refreshTokenLifetime.Seconds()),
+	).Int()
+	if err != nil {
+		return nil, err
+	}
+
+	switch result {
+	case 0:
+		return nil, ErrSessionRevoked
+	case -1:
+		if err := RevokeSession(user.ID, sessionID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	storage.Redis.Expire(bgContext, userSessionsKey(user.ID), refreshTokenLifetime)
+
+	return createTokenPair(user, sessionID, nextRefreshTokenID)
+}
+
+// ListSessions returns the user's sessions, most recently active first.
+func ListSessions(userID uint) ([]Session, error) {
+	sessionIDs, err := storage.Redis.SMembers(bgContext, userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		fields, err := storage.Redis.HGetAll(bgContext, sessionKey(sessionID)).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		// Expired sessions linger in the set until they are listed.
+		if len(fields) == 0 {
+			storage.Redis.SRem(bgContext, userSessionsKey(userID), sessionID)
+			continue
+		}
+
+		sessions = append(sessions, Session{
+			ID:         sessionID,
+			UserID:     userID,
+			DeviceName: fields["device_name"],
+			UserAgent:  fields["user_agent"],
+			IP:         fields["ip"],
+			CreatedAt:  unixField(fields["created_at"]),
+			LastSeenAt: unixField(fields["last_seen_at"]),
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+// RevokeSession signs a session out. Its refresh token stops working and
+// SessionValidator rejects its access tokens.
+func RevokeSession(userID uint, sessionID string) error {
+	_, err := storage.Redis.TxPipelined(bgContext, func(pipe redis.Pipeliner) error {
+		pipe.Del(bgContext, sessionKey(sessionID))
+		pipe.SRem(bgContext, userSessionsKey(userID), sessionID)
+		return nil
+	})
+
+	return err
+}
+
+// RevokeAllSessions signs the user out everywhere. Tokens issued before
+// sessions existed aren't indexed by user, so a cutoff is recorded as well
+// and any such token issued before it is rejected.
+func RevokeAllSessions(userID uint) error {
+	cutoff := time.Now().Unix()
+	if err := storage.Redis.Set(bgContext, revokedBeforeKey(userID), cutoff, refreshTokenLifetime).Err(); err != nil {
+		return err
+	}
+
+	key := userSessionsKey(userID)
+	legacyKey := "refresh_tokens:" + strconv.FormatUint(uint64(userID), 10)
+
+	sessionIDs, err := storage.Redis.SMembers(bgContext, key).Result()
+	if err != nil {
+		return err
+	}
+
+	legacyTokens, err := storage.Redis.SMembers(bgContext, legacyKey).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := append([]string{key, legacyKey}, legacyTokens...)
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, sessionKey(sessionID))
+	}
+
+	return storage.Redis.Del(bgContext, keys...).Err()
+}
+
+// SessionOwner returns the user a session belongs to, or ErrSessionRevoked.
+func SessionOwner(sessionID string) (uint, error) {
+	userID, err := storage.Redis.HGet(bgContext, sessionKey(sessionID), "user_id").Uint64()
+	if err == redis.Nil {
+		return 0, ErrSessionRevoked
+	}
+
+	return uint(userID), err
+}
+
+// CurrentSessionID returns the session of the request's access token.
+func CurrentSessionID(ctx iris.Context) string {
+	token := jwt.GetVerifiedToken(ctx)
+	if token == nil {
+		return ""
+	}
+
+	return token.StandardClaims.ID
+}
+
+// SessionValidator rejects access tokens whose session has been revoked and
+// records the session as seen. Tokens issued before sessions existed carry
+// no session; they are accepted until they expire unless the user was
+// signed out everywhere after they were issued.
+type SessionValidator struct{}
+
+func (SessionValidator) ValidateToken(token []byte, claims jwt.Claims, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if claims.ID == "" {
+		return validateLegacyAccessToken(token, claims)
+	}
+
+	exists, scriptErr := touchSessionScript.Run(bgContext, storage.Redis, []string{sessionKey(claims.ID)},
+		time.Now().Unix()).Int()
+	if scriptErr != nil {
+		// Fail open like the rate limiter: a Redis outage shouldn't sign
+		// everyone out.
+		return nil
+	}
+
+	if exists == 0 {
+		return jwt.ErrBlocked
+	}
+
+	return nil
+}
+
+func validateLegacyAccessToken(token []byte, claims jwt.Claims) error {
+	// The signature has been checked by now; only the user ID is needed
+	// from the payload.
+	parts := bytes.Split(token, []byte("."))
+	if len(parts) != 3 {
+		return jwt.ErrTokenForm
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(string(parts[1]))
+	if err != nil {
+		return err
+	}
+
+	var accessToken AccessToken
+	if err := json.Unmarshal(payload, &accessToken); err != nil {
+		return err
+	}
+
+	revoked, err := IssuedBeforeRevocation(accessToken.ID, claims.IssuedAt)
+	if err != nil {
+		return nil
+	}
+
+	if revoked {
+		return jwt.ErrBlocked
+	}
+
+	return nil
+}
+
+// IssuedBeforeRevocation reports whether a token issued at issuedAt, in
+// Unix seconds, predates the user's last RevokeAllSessions. Tokens issued
+// in the same second are treated as revoked.
+func IssuedBeforeRevocation(userID uint, issuedAt int64) (bool, error) {
+	cutoff, err := storage.Redis.Get(bgContext, revokedBeforeKey(userID)).Int64()
+	if err == redis.Nil {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return issuedAt <= cutoff, nil
+}
+
+func unixField(value string) time.Time {
+	seconds, _ := strconv.ParseInt(value, 10, 64)
+	return time.Unix(seconds, 0)
+}
+
+func sessionKey(sessionID string) string {
+	return "session:" + sessionID
+}
+
+func userSessionsKey(userID uint) string {
+	return "user_sessions:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func revokedBeforeKey(userID uint) string {
+	return "revoked_before:" + strconv.FormatUint(uint64(userID), 10)
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"context"
+	"errors"
+	"habitat-server/models"
 	"habitat-server/storage"
 	"os"
 	"strconv"
@@ -9,18 +11,51 @@ import (
 
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/middleware/jwt"
+	"github.com/thanhpk/randstr"
 )
 
 var bgContext = context.Background()
 
+const forgotPasswordTokenLifetime = 10 * time.Minute
+
+// CreateForgotPasswordToken signs the token sent in a password reset link.
+// It carries a jti so UseForgotPasswordToken can accept it only once.
 func CreateForgotPasswordToken(id uint, email string) (string, error) {
-	signer := jwt.NewSigner(jwt.HS256, os.Getenv("EMAIL_TOKEN_SECRET"), 10*time.Minute)
+	signer := jwt.NewSigner(jwt.HS256, os.Getenv("EMAIL_TOKEN_SECRET"), forgotPasswordTokenLifetime)
 
 	claims := ForgotPasswordToken{
 		ID:    id,
 		Email: email,
 	}
 
+	token, err := signer.Sign(claims, jwt.Claims{ID: randstr.Hex(16)})
+	if err != nil {
+		return "", err
+	}
+
+	return string(token), nil
+}
+
+// UseForgotPasswordToken marks a reset token's jti as used and reports
+// whether it was unused before. Tokens without a jti are never accepted.
+func UseForgotPasswordToken(tokenID string) (bool, error) {
+	if tokenID == "" {
+		return false, nil
+	}
+
+	return storage.Redis.SetNX(bgContext, "used_reset_token:"+tokenID, "true", forgotPasswordTokenLifetime).Result()
+}
+
+// CreateEmailVerificationToken signs the token sent in the verification
+// link. It uses its own secret so it can't be replayed as a reset token.
+func CreateEmailVerificationToken(id uint, email string) (string, error) {
+	signer := jwt.NewSigner(jwt.HS256, os.Getenv("VERIFY_TOKEN_SECRET"), 24*time.Hour)
+
+	claims := EmailVerificationToken{
+		ID:    id,
+		Email: email,
+	}
+
 	token, err := signer.Sign(claims)
 	if err != nil {
 		return "", err
@@ -29,19 +64,73 @@ func CreateForgotPasswordToken(id uint, email string) (string, error) {
 	return string(token), nil
 }
 
-func CreateTokenPair(id uint) (*jwt.TokenPair, error) {
-	accessTokenSigner := jwt.NewSigner(jwt.HS256, os.Getenv("ACCESS_TOKEN_SECRET"), 24*time.Hour)
-	refreshTokenSigner := jwt.NewSigner(jwt.HS256, os.Getenv("REFRESH_TOKEN_SECRET"), 365*24*time.Hour)
+// CreateAccountLinkToken signs a pending link between a social identity and
+// an existing account. It is handed to the client when a social login
+// matches an account's email, and only takes effect once the account's owner
+// presents it while signed in.
+func CreateAccountLinkToken(userID uint, provider string, subject string, email string) (string, error) {
+	signer := jwt.NewSigner(jwt.HS256, os.Getenv("LINK_TOKEN_SECRET"), 10*time.Minute)
 
-	userID := strconv.FormatUint(uint64(id), 10)
+	claims := AccountLinkToken{
+		ID:       userID,
+		Provider: provider,
+		Subject:  subject,
+		Email:    email,
+	}
 
-	refreshClaims := jwt.Claims{Subject: userID}
+	token, err := signer.Sign(claims)
+	if err != nil {
+		return "", err
+	}
+
+	return string(token), nil
+}
+
+func ParseAccountLinkToken(token string) (*AccountLinkToken, error) {
+	verifiedToken, err := jwt.Verify(jwt.HS256, []byte(os.Getenv("LINK_TOKEN_SECRET")), []byte(token))
+	if err != nil {
+		return nil, err
+	}
+
+	var claims AccountLinkToken
+	if err := verifiedToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+const (
+	accessTokenLifetime  = 24 * time.Hour
+	refreshTokenLifetime = 365 * 24 * time.Hour
+)
+
+// createTokenPair signs an access and refresh token for a session. The
+// access token carries the user's role and permissions, so changes to them
+// take effect on the next refresh. Its jti is the session ID, which lets
+// SessionValidator reject it once the session is revoked. The refresh token
+// carries the session as origin_jti and its own ID as jti.
+func createTokenPair(user *models.User, sessionID string, refreshTokenID string) (*jwt.TokenPair, error) {
+	accessTokenSigner := jwt.NewSigner(jwt.HS256, os.Getenv("ACCESS_TOKEN_SECRET"), accessTokenLifetime)
+	refreshTokenSigner := jwt.NewSigner(jwt.HS256, os.Getenv("REFRESH_TOKEN_SECRET"), refreshTokenLifetime)
+
+	refreshClaims := jwt.Claims{
+		Subject:  strconv.FormatUint(uint64(user.ID), 10),
+		ID:       refreshTokenID,
+		OriginID: sessionID,
+	}
 
+	// Login only issues tokens after the second factor when two-factor is
+	// enabled, so every pair for such a user is multi-factor.
 	accessTokenClaims := AccessToken{
-		ID: id,
+		ID:                user.ID,
+		Role:              user.Role,
+		Permissions:       user.AllPermissions(),
+		MFA:               user.TwoFactorEnabled,
+		TwoFactorRequired: user.TwoFactorRequired,
 	}
 
-	accessToken, err := accessTokenSigner.Sign(accessTokenClaims)
+	accessToken, err := accessTokenSigner.Sign(accessTokenClaims, jwt.Claims{ID: sessionID})
 	if err != nil {
 		return nil, err
 	}
@@ -55,35 +144,60 @@ func CreateTokenPair(id uint) (*jwt.TokenPair, error) {
 	tokenPair.AccessToken = accessToken
 	tokenPair.RefreshToken = refreshToken
 
-	storage.Redis.Set(bgContext, string(refreshToken), "true", 365*24*time.Hour+5*time.Minute)
-
 	return &tokenPair, nil
 }
 
+// RefreshToken exchanges a refresh token for a new pair. Each refresh token
+// can be used once; presenting one that has already been rotated means it
+// was stolen or replayed, so the whole session is revoked.
 func RefreshToken(ctx iris.Context) {
 	token := jwt.GetVerifiedToken(ctx)
-	tokenStr := string(token.Token)
-	validToken, tokenErr := storage.Redis.Get(bgContext, tokenStr).Result()
+	claims := token.StandardClaims
+
+	userID, parseErr := strconv.ParseUint(claims.Subject, 10, 32)
+	if parseErr != nil {
+		CreateInternalServerError(ctx)
+		return
+	}
+
+	var user models.User
+	userExists := storage.DB.Find(&user, userID)
+	if userExists.Error != nil {
+		CreateInternalServerError(ctx)
+		return
+	}
 
-	if tokenErr != nil {
+	if userExists.RowsAffected == 0 {
 		CreateNotFound(ctx)
 		return
 	}
 
-	if validToken != "true" {
-		ctx.StatusCode(iris.StatusForbidden)
+	if user.SuspendedAt != nil {
+		CreateAccountSuspended(ctx)
 		return
 	}
 
-	storage.Redis.Del(bgContext, tokenStr)
-	userID, parseErr := strconv.ParseUint(token.StandardClaims.Subject, 10, 32)
-	if parseErr != nil {
-		CreateInternalServerError(ctx)
+	// Sessions from before two-factor became mandatory for the user end
+	// here; signing in again leads to enrollment.
+	if TwoFactorMandatory(user.Role, user.TwoFactorRequired) && !user.TwoFactorEnabled {
+		CreateTwoFactorRequired(ctx)
 		return
 	}
 
-	tokenPair, tokenPairErr := CreateTokenPair(uint(userID))
-	if tokenPairErr != nil {
+	var tokenPair *jwt.TokenPair
+	var err error
+	if claims.OriginID == "" {
+		tokenPair, err = upgradeLegacyRefreshToken(&user, string(token.Token), claims.IssuedAt, ctx)
+	} else {
+		tokenPair, err = rotateSession(&user, claims.OriginID, claims.ID, ctx)
+	}
+
+	if errors.Is(err, ErrSessionRevoked) || errors.Is(err, ErrRefreshTokenReused) {
+		CreateError(iris.StatusUnauthorized, "Session Expired", "Sign in again to continue.", ctx)
+		return
+	}
+
+	if err != nil {
 		CreateInternalServerError(ctx)
 		return
 	}
@@ -94,13 +208,56 @@ func RefreshToken(ctx iris.Context) {
 	})
 }
 
+// upgradeLegacyRefreshToken accepts a refresh token issued before sessions
+// existed, which was stored as token -> "true", once, and moves it onto a
+// new session. Tokens issued before the user was last signed out everywhere
+// are refused.
+func upgradeLegacyRefreshToken(user *models.User, token string, issuedAt int64, ctx iris.Context) (*jwt.TokenPair, error) {
+	revoked, err := IssuedBeforeRevocation(user.ID, issuedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		storage.Redis.Del(bgContext, token)
+		return nil, ErrSessionRevoked
+	}
+
+	deleted, err := storage.Redis.Del(bgContext, token).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if deleted == 0 {
+		return nil, ErrSessionRevoked
+	}
+
+	return StartSession(user, ctx)
+}
+
 type ForgotPasswordToken struct {
 	ID    uint   `json:"ID"`
 	Email string `json:"email"`
 }
 
+type EmailVerificationToken struct {
+	ID    uint   `json:"ID"`
+	Email string `json:"email"`
+}
+
+type AccountLinkToken struct {
+	ID       uint   `json:"ID"`
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+	Email    string `json:"email"`
+}
+
 type AccessToken struct {
-	ID uint `json:"ID"`
+	ID                uint                `json:"ID"`
+	Role              models.Role         `json:"role"`
+	Permissions       []models.Permission `json:"permissions"`
+	MFA               bool                `json:"mfa"`
+	TwoFactorRequired bool                `json:"twoFactorRequired"`
 }
 
 type RefreshTokenInput struct {
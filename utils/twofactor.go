@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"habitat-server/models"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+)
+
+var ErrInvalidEncryptionKey = errors.New("TWO_FACTOR_ENCRYPTION_KEY must be 32 base64-encoded bytes")
+
+const recoveryCodeCount = 10
+
+func twoFactorCipher() (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("TWO_FACTOR_ENCRYPTION_KEY"))
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidEncryptionKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// SealTOTPSecret encrypts a TOTP secret for storage. The nonce is prepended
+// to the ciphertext.
+func SealTOTPSecret(secret string) (string, error) {
+	gcm, err := twoFactorCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func OpenTOTPSecret(sealed string) (string, error) {
+	gcm, err := twoFactorCipher()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return "", errors.New("malformed TOTP secret")
+	}
+
+	secret, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret), nil
+}
+
+// GenerateRecoveryCodes returns fresh codes formatted as xxxxx-xxxxx.
+func GenerateRecoveryCodes() ([]string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(raw))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+
+	return codes, nil
+}
+
+// NormalizeRecoveryCode lets users type codes without the dash or in
+// upper case.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if len(code) != 10 {
+		return code
+	}
+
+	return code[:5] + "-" + code[5:]
+}
+
+// CreateTwoFactorChallengeToken signs the short-lived token Login returns
+// in place of a token pair when the user has two-factor enabled.
+func CreateTwoFactorChallengeToken(id uint) (string, error) {
+	signer := jwt.NewSigner(jwt.HS256, os.Getenv("TWO_FACTOR_CHALLENGE_SECRET"), 5*time.Minute)
+
+	token, err := signer.Sign(TwoFactorChallengeToken{ID: id})
+	if err != nil {
+		return "", err
+	}
+
+	return string(token), nil
+}
+
+// TwoFactorEnrollmentAudience marks enrollment tokens, so no other token
+// signed with the same secret passes for one.
+const TwoFactorEnrollmentAudience = "two_factor_enrollment"
+
+// CreateTwoFactorEnrollmentToken signs the token login returns in place of
+// a token pair when the user must use two-factor but hasn't enabled it. It
+// is only good for enrolling.
+func CreateTwoFactorEnrollmentToken(id uint) (string, error) {
+	signer := jwt.NewSigner(jwt.HS256, os.Getenv("TWO_FACTOR_ENROLLMENT_SECRET"), 15*time.Minute)
+
+	token, err := signer.Sign(TwoFactorEnrollmentToken{ID: id}, jwt.Claims{Audience: []string{TwoFactorEnrollmentAudience}})
+	if err != nil {
+		return "", err
+	}
+
+	return string(token), nil
+}
+
+// TwoFactorChallengeKey rate limits second-factor attempts per challenged
+// user rather than per IP.
+func TwoFactorChallengeKey(ctx iris.Context) string {
+	if claims, ok := jwt.Get(ctx).(*TwoFactorChallengeToken); ok && claims != nil {
+		return "user:" + strconv.FormatUint(uint64(claims.ID), 10)
+	}
+
+	return "ip:" + ctx.RemoteAddr()
+}
+
+// TwoFactorMandatory reports whether a user must use two-factor: when an
+// admin requires it, or for staff and admins unless STAFF_REQUIRE_2FA is
+// "false".
+func TwoFactorMandatory(role models.Role, required bool) bool {
+	if required {
+		return true
+	}
+
+	staff := role == models.StaffRole || role == models.AdminRole
+	return staff && os.Getenv("STAFF_REQUIRE_2FA") != "false"
+}
+
+type TwoFactorChallengeToken struct {
+	ID uint `json:"ID"`
+}
+
+type TwoFactorEnrollmentToken struct {
+	ID uint `json:"ID"`
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"habitat-server/models"
+	"habitat-server/storage"
+	"os"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/middleware/jwt"
+)
+
+// Actions that can be withheld from accounts with an unverified email.
+const (
+	RestrictMessaging      = "messaging"
+	RestrictCreateProperty = "create_property"
+	RestrictReservations   = "reservations"
+)
+
+// defaultUnverifiedRestrictions applies when UNVERIFIED_RESTRICTIONS is
+// unset. Set it to "none" to allow everything.
+var defaultUnverifiedRestrictions = []string{RestrictMessaging, RestrictCreateProperty}
+
+func unverifiedRestrictions() []string {
+	configured := os.Getenv("UNVERIFIED_RESTRICTIONS")
+	if configured == "" {
+		return defaultUnverifiedRestrictions
+	}
+
+	var restrictions []string
+	for _, restriction := range strings.Split(configured, ",") {
+		restrictions = append(restrictions, strings.TrimSpace(restriction))
+	}
+
+	return restrictions
+}
+
+// IsEmailVerified reads the flag from the database so a verification takes
+// effect without waiting for a new access token.
+func IsEmailVerified(userID uint) (bool, error) {
+	var user models.User
+	err := storage.DB.Select("id", "is_verified").First(&user, userID).Error
+	if err != nil {
+		return false, err
+	}
+
+	return user.IsVerified != nil && *user.IsVerified, nil
+}
+
+// RequireVerified rejects unverified accounts when restriction is one of
+// the configured restrictions. It must run after the access token verifier.
+func RequireVerified(restriction string) iris.Handler {
+	return func(ctx iris.Context) {
+		restricted := false
+		for _, r := range unverifiedRestrictions() {
+			if r == restriction {
+				restricted = true
+				break
+			}
+		}
+
+		if !restricted {
+			ctx.Next()
+			return
+		}
+
+		claims := jwt.Get(ctx).(*AccessToken)
+
+		verified, err := IsEmailVerified(claims.ID)
+		if err != nil {
+			CreateInternalServerError(ctx)
+			return
+		}
+
+		if !verified {
+			CreateEmailNotVerified(ctx)
+			return
+		}
+
+		ctx.Next()
+	}
+}